@@ -188,15 +188,20 @@ var _escData = map[string]*_escFile{
 
 	"/static/templates.tpl": {
 		local:   "static/templates.tpl",
-		size:    535,
-		modtime: 1461446549,
+		size:    1533,
+		modtime: 1792173596,
 		compressed: `
-H4sIAAAJbogA/1SRMU/DMBCFd37FKe3QRjTZIzEgYEBCDIVOVUWc5NpacpzIsYHK8n/nYruhmaK7e+/T
-y7O1DR65REh69VV3bYtSJ7Bx7s5afoTsk2uBfl4sFmBtWNBI9w2gbCblE5m5fn324jQNI2zxiApljcVo
-vmqcS9M5IfswbcvUJZBH3q4XHWuGiHschq7mTGMD8RAIiskTwrLh6h6WJlqKh7ndA2WQQZIlkVlaO26c
-K0qC3aaJ1MAbcRPaO2FPzrDJ3llLdRxW/5vd9s25NVGutNuWYqyX356r0Os16A8XAioE9s24YBW1bqTm
-Aijl3OVcOUF9zfRkUCv05VQX2JemVxDfsjyszlr3Q5HnJ67PpsrokNeiM03XDzkp19n83/33LwAA///i
-Cn6TFwIAAA==
+H4sIAAAJbogA/5VUS2/aQBC+8yumDpXAqu1jJYtGapNWqtS0EUlOUYQXeyErzNraXYcg4/+e2RexQ3Io
+BzzPb2a/2Z22LeiKcQpBLRZ5td1SrgKIum7UtjumHiG+ImJDRde1baz/2ArG8QUGMvX7sutmn6IImlpE
+uTGlbdv3QhSdty3lhcHDr0NGkPiWqZIa/ezsDBDdGFBFfwQ+R0d6PBMchlaFOV1RQXlOU538WjMMhwjx
+TbPdErG3yPZMt1SqOZVNqaQD1RZwJo1wgNtKkRIOcE2kpAUKvwgrjXCzYXVtpMtGEMUqDofRITK/1H3f
+EXoSilhBn1kXQZ6MYit5zZbzmqvpVV9Y6/pYgvA1hfEKcxpBIf0GJh9le8BZQRXq8nw0k5aO8/RZE+dT
+4r9kS/18ve2KSknWaB5EHq1usrPEQ46G6Ze2JpbPsmzUQ3jr8BdjlhzbHH10be7qsiKFH9t3KaucEYXT
+cA47fsdHwcQXGDcuRbPSTzeA3IZBEAcOM8NO0YKnzhCsf5UcqsXTcEfoI5yzuOlZRE2eM1uWId0RwRlf
+pxA6sJWJd+VKSV3m/dvUh8mr5W7+p+umgw7ffnuM/XyumaCnTF4L9oQEdp3VoWR8I4FgaG09QHAAO1aW
+QC0EUVgAe/Qp0nqX6HnCY5AlvuuGK1a6PgAZHXbRddmxSfOecetALqgZ5HIP9xmuFHDrKHuYPCpVyzRJ
+1vh6m2WMjiQvq6aoaplg5DQO32XhdLUtqkYVukrw0bP45wKGqwcPMbEbDoeJvHC1guBz/FUG/Zjp6YvQ
+C+hHVeyHt/u12V6X+SPNNwuX6Xdwf3uFFzoCRMPf4Uq7/pepFzOm45L9BQAA
 `,
 	},
 
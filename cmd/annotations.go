@@ -0,0 +1,293 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	AUTO       string = "auto"
+	GCC        string = "gcc"
+	GOVET      string = "govet"
+	GOLANGCI   string = "golangci"
+	ESLINT     string = "eslint"
+	CHECKSTYLE string = "checkstyle"
+
+	MAX_ANNOTATIONS int = 50 // the number of annotations Github accepts per request
+)
+
+var (
+	// matches both 'file:line:col: error: message' (gcc/clang) and 'file:line:col: message' (go vet)
+	diagnostic_line = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)?\s*(?:(fatal error|error|warning|note):\s*)?(.+)$`)
+	// a file path without spaces and with an extension, required for the lines without a severity
+	source_path = regexp.MustCompile(`^\S+\.\w+$`)
+)
+
+type golangciReport struct {
+	Issues []struct {
+		FromLinter string
+		Text       string
+		Severity   string
+		Pos        struct {
+			Filename string
+			Line     int
+			Column   int
+		}
+	}
+}
+
+type eslintReport []struct {
+	FilePath string `json:"filePath"`
+	Messages []struct {
+		RuleID   string `json:"ruleId"`
+		Severity int    `json:"severity"`
+		Message  string `json:"message"`
+		Line     int    `json:"line"`
+		EndLine  int    `json:"endLine"`
+	} `json:"messages"`
+}
+
+type checkstyleReport struct {
+	Files []struct {
+		Name   string `xml:"name,attr"`
+		Errors []struct {
+			Line     int    `xml:"line,attr"`
+			Severity string `xml:"severity,attr"`
+			Message  string `xml:"message,attr"`
+			Source   string `xml:"source,attr"`
+		} `xml:"error"`
+	} `xml:"file"`
+}
+
+// Read the 'annotations_file' (or stdin if it is '-') and parse it based on the 'annotations_format'
+func readAnnotations(path, format string) ([]CheckRunAnnotation, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading annotations_file '%s': %s", path, err.Error())
+	}
+	return parseAnnotations(data, format)
+}
+
+// Convert diagnostic output into check run annotations
+func parseAnnotations(data []byte, format string) ([]CheckRunAnnotation, error) {
+	if format == AUTO {
+		format = detectAnnotationsFormat(data)
+	}
+	switch format {
+	case GOLANGCI:
+		return parseGolangci(data)
+	case ESLINT:
+		return parseEslint(data)
+	case CHECKSTYLE:
+		return parseCheckstyle(data)
+	default: // GCC and GOVET
+		return parseDiagnosticLines(data, format), nil
+	}
+}
+
+// Guess the format of the diagnostic output from its first character, or from the severities of its lines
+func detectAnnotationsFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return GCC
+	}
+	switch trimmed[0] {
+	case '<':
+		return CHECKSTYLE
+	case '[':
+		return ESLINT
+	case '{':
+		return GOLANGCI
+	}
+
+	// go vet does not include a severity, so it is only detected if none of the lines has one
+	govet := false
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for scanner.Scan() {
+		match := diagnostic_line.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil {
+			continue
+		}
+		if match[4] != "" {
+			return GCC
+		}
+		if source_path.MatchString(match[1]) {
+			govet = true
+		}
+	}
+	if govet {
+		return GOVET
+	}
+	return GCC
+}
+
+// Parse 'file:line:col: [error|warning|note:] message' lines, ignoring anything else (like source excerpts).
+// The severity is only optional with the 'govet' format, and then the file must look like a source file.
+func parseDiagnosticLines(data []byte, format string) []CheckRunAnnotation {
+	annotations := []CheckRunAnnotation{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		match := diagnostic_line.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil {
+			continue
+		}
+		if match[4] == "" && (format != GOVET || !source_path.MatchString(match[1])) {
+			continue
+		}
+		line, _ := strconv.Atoi(match[2])
+		level := "failure" // go vet does not include a severity
+		switch match[4] {
+		case "warning":
+			level = "warning"
+		case "note":
+			level = "notice"
+		}
+		annotations = append(annotations, CheckRunAnnotation{
+			Path:            annotationPath(match[1]),
+			StartLine:       line,
+			EndLine:         line,
+			AnnotationLevel: level,
+			Message:         match[5],
+		})
+	}
+	return annotations
+}
+
+// Parse the output of 'golangci-lint run --out-format json'
+func parseGolangci(data []byte) ([]CheckRunAnnotation, error) {
+	report := &golangciReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("parsing golangci-lint json: %s", err.Error())
+	}
+	annotations := []CheckRunAnnotation{}
+	for _, issue := range report.Issues {
+		level := "failure"
+		if strings.ToLower(issue.Severity) == "warning" {
+			level = "warning"
+		}
+		annotations = append(annotations, CheckRunAnnotation{
+			Path:            annotationPath(issue.Pos.Filename),
+			StartLine:       issue.Pos.Line,
+			EndLine:         issue.Pos.Line,
+			AnnotationLevel: level,
+			Message:         fmt.Sprintf("%s (%s)", issue.Text, issue.FromLinter),
+		})
+	}
+	return annotations, nil
+}
+
+// Parse the output of 'eslint --format json'
+func parseEslint(data []byte) ([]CheckRunAnnotation, error) {
+	report := eslintReport{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("parsing eslint json: %s", err.Error())
+	}
+	annotations := []CheckRunAnnotation{}
+	for _, file := range report {
+		for _, m := range file.Messages {
+			level := "warning"
+			if m.Severity == 2 {
+				level = "failure"
+			}
+			end := m.EndLine
+			if end < m.Line {
+				end = m.Line
+			}
+			message := m.Message
+			if m.RuleID != "" {
+				message = fmt.Sprintf("%s (%s)", m.Message, m.RuleID)
+			}
+			annotations = append(annotations, CheckRunAnnotation{
+				Path:            annotationPath(file.FilePath),
+				StartLine:       m.Line,
+				EndLine:         end,
+				AnnotationLevel: level,
+				Message:         message,
+			})
+		}
+	}
+	return annotations, nil
+}
+
+// Parse a checkstyle xml report
+func parseCheckstyle(data []byte) ([]CheckRunAnnotation, error) {
+	report := &checkstyleReport{}
+	if err := xml.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("parsing checkstyle xml: %s", err.Error())
+	}
+	annotations := []CheckRunAnnotation{}
+	for _, file := range report.Files {
+		for _, e := range file.Errors {
+			level := "notice"
+			switch strings.ToLower(e.Severity) {
+			case "error":
+				level = "failure"
+			case "warning":
+				level = "warning"
+			}
+			line := e.Line
+			if line < 1 { // file level errors do not have a line
+				line = 1
+			}
+			annotations = append(annotations, CheckRunAnnotation{
+				Path:            annotationPath(file.Name),
+				StartLine:       line,
+				EndLine:         line,
+				AnnotationLevel: level,
+				Message:         e.Message,
+			})
+		}
+	}
+	return annotations, nil
+}
+
+// Github expects the path of an annotation to be relative to the root of the repo
+func annotationPath(path string) string {
+	if filepath.IsAbs(path) {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+		}
+	}
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "./")
+}
+
+// Split the annotations into batches Github will accept in a single request
+func annotationBatches(annotations []CheckRunAnnotation) [][]CheckRunAnnotation {
+	batches := [][]CheckRunAnnotation{}
+	for len(annotations) > MAX_ANNOTATIONS {
+		batches = append(batches, annotations[:MAX_ANNOTATIONS])
+		annotations = annotations[MAX_ANNOTATIONS:]
+	}
+	return append(batches, annotations)
+}
@@ -0,0 +1,205 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// A shorthand for the expected annotations
+func annotation(path string, start, end int, level, message string) CheckRunAnnotation {
+	return CheckRunAnnotation{Path: path, StartLine: start, EndLine: end, AnnotationLevel: level, Message: message}
+}
+
+func TestParseDiagnosticLines(t *testing.T) {
+	gcc := "src/main.c:12:5: error: 'x' undeclared\n" +
+		"   12 |     x = 1;\n" +
+		"      |     ^\n" +
+		"./src/util.h:3: warning: unused variable 'y'\n" +
+		"src/main.c:20:1: note: declared here\n" +
+		"Retrying at 12:30: connection refused\n"
+	govet := "# example.com/pkg\n" +
+		"x.go:3:1: unreachable code\n" +
+		"vet: pkg/y.go:10:2: printf format %d has arg of wrong type\n" +
+		"pkg/y_test.go:7:9: result of fmt.Sprintf call not used\n"
+	cases := []struct {
+		name     string
+		data     string
+		format   string
+		expected []CheckRunAnnotation
+	}{
+		{"gcc", gcc, GCC, []CheckRunAnnotation{
+			annotation("src/main.c", 12, 12, "failure", "'x' undeclared"),
+			annotation("src/util.h", 3, 3, "warning", "unused variable 'y'"),
+			annotation("src/main.c", 20, 20, "notice", "declared here"),
+		}},
+		{"gcc auto", gcc, AUTO, []CheckRunAnnotation{
+			annotation("src/main.c", 12, 12, "failure", "'x' undeclared"),
+			annotation("src/util.h", 3, 3, "warning", "unused variable 'y'"),
+			annotation("src/main.c", 20, 20, "notice", "declared here"),
+		}},
+		{"govet", govet, GOVET, []CheckRunAnnotation{
+			annotation("x.go", 3, 3, "failure", "unreachable code"),
+			annotation("pkg/y_test.go", 7, 7, "failure", "result of fmt.Sprintf call not used"),
+		}},
+		{"govet auto", govet, AUTO, []CheckRunAnnotation{
+			annotation("x.go", 3, 3, "failure", "unreachable code"),
+			annotation("pkg/y_test.go", 7, 7, "failure", "result of fmt.Sprintf call not used"),
+		}},
+		{"govet as gcc", govet, GCC, []CheckRunAnnotation{}},
+		{"no diagnostics", "Retrying at 12:30: connection refused\nhttp://host:8080: down\n", AUTO, []CheckRunAnnotation{}},
+		{"empty", "", AUTO, []CheckRunAnnotation{}},
+	}
+	for _, c := range cases {
+		annotations, err := parseAnnotations([]byte(c.data), c.format)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if !reflect.DeepEqual(annotations, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, annotations)
+		}
+	}
+}
+
+func TestParseGolangci(t *testing.T) {
+	cases := []struct {
+		data     string
+		expected []CheckRunAnnotation
+	}{
+		{`{"Issues": [
+			{"FromLinter": "errcheck", "Text": "Error return value is not checked", "Pos": {"Filename": "cmd/run.go", "Line": 42, "Column": 3}},
+			{"FromLinter": "gocritic", "Text": "ifElseChain", "Severity": "warning", "Pos": {"Filename": "./cmd/check.go", "Line": 7}}
+		]}`, []CheckRunAnnotation{
+			annotation("cmd/run.go", 42, 42, "failure", "Error return value is not checked (errcheck)"),
+			annotation("cmd/check.go", 7, 7, "warning", "ifElseChain (gocritic)"),
+		}},
+		{`{"Issues": null}`, []CheckRunAnnotation{}},
+	}
+	for _, c := range cases {
+		annotations, err := parseAnnotations([]byte(c.data), AUTO)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(annotations, c.expected) {
+			t.Errorf("expected %v, got %v", c.expected, annotations)
+		}
+	}
+	if _, err := parseAnnotations([]byte(`{"Issues": [`), GOLANGCI); err == nil {
+		t.Errorf("expected an error for invalid json")
+	}
+}
+
+func TestParseEslint(t *testing.T) {
+	cases := []struct {
+		data     string
+		expected []CheckRunAnnotation
+	}{
+		{`[
+			{"filePath": "src/app.js", "messages": [
+				{"ruleId": "no-unused-vars", "severity": 2, "message": "'a' is defined but never used.", "line": 3, "endLine": 3},
+				{"ruleId": "max-len", "severity": 1, "message": "Line is too long.", "line": 10, "endLine": 12},
+				{"ruleId": null, "severity": 2, "message": "Parsing error: Unexpected token", "line": 20}
+			]},
+			{"filePath": "src/clean.js", "messages": []}
+		]`, []CheckRunAnnotation{
+			annotation("src/app.js", 3, 3, "failure", "'a' is defined but never used. (no-unused-vars)"),
+			annotation("src/app.js", 10, 12, "warning", "Line is too long. (max-len)"),
+			annotation("src/app.js", 20, 20, "failure", "Parsing error: Unexpected token"),
+		}},
+		{`[]`, []CheckRunAnnotation{}},
+	}
+	for _, c := range cases {
+		annotations, err := parseAnnotations([]byte(c.data), AUTO)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(annotations, c.expected) {
+			t.Errorf("expected %v, got %v", c.expected, annotations)
+		}
+	}
+	if _, err := parseAnnotations([]byte(`[{`), ESLINT); err == nil {
+		t.Errorf("expected an error for invalid json")
+	}
+}
+
+func TestParseCheckstyle(t *testing.T) {
+	cases := []struct {
+		data     string
+		expected []CheckRunAnnotation
+	}{
+		{`<?xml version="1.0" encoding="UTF-8"?>
+			<checkstyle version="8.0">
+				<file name="src/Main.java">
+					<error line="5" severity="error" message="Missing a Javadoc comment." source="JavadocMethodCheck"/>
+					<error line="9" severity="warning" message="Line is longer than 100 characters."/>
+					<error severity="info" message="File does not end with a newline."/>
+				</file>
+				<file name="src/Clean.java"/>
+			</checkstyle>`, []CheckRunAnnotation{
+			annotation("src/Main.java", 5, 5, "failure", "Missing a Javadoc comment."),
+			annotation("src/Main.java", 9, 9, "warning", "Line is longer than 100 characters."),
+			annotation("src/Main.java", 1, 1, "notice", "File does not end with a newline."),
+		}},
+		{`<checkstyle/>`, []CheckRunAnnotation{}},
+	}
+	for _, c := range cases {
+		annotations, err := parseAnnotations([]byte(c.data), AUTO)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(annotations, c.expected) {
+			t.Errorf("expected %v, got %v", c.expected, annotations)
+		}
+	}
+	if _, err := parseAnnotations([]byte(`<checkstyle><file>`), CHECKSTYLE); err == nil {
+		t.Errorf("expected an error for invalid xml")
+	}
+}
+
+func TestAnnotationBatches(t *testing.T) {
+	cases := []struct {
+		count int
+		sizes []int
+	}{
+		{0, []int{0}},
+		{1, []int{1}},
+		{MAX_ANNOTATIONS, []int{MAX_ANNOTATIONS}},
+		{MAX_ANNOTATIONS + 1, []int{MAX_ANNOTATIONS, 1}},
+		{2*MAX_ANNOTATIONS + 7, []int{MAX_ANNOTATIONS, MAX_ANNOTATIONS, 7}},
+	}
+	for _, c := range cases {
+		annotations := []CheckRunAnnotation{}
+		for i := 0; i < c.count; i++ {
+			annotations = append(annotations, annotation(fmt.Sprintf("file%d.go", i), i+1, i+1, "failure", "problem"))
+		}
+		batches := annotationBatches(annotations)
+		sizes := []int{}
+		next := 0
+		for _, batch := range batches {
+			sizes = append(sizes, len(batch))
+			for _, a := range batch {
+				if a.StartLine != next+1 {
+					t.Errorf("%d annotations: expected the annotations to stay in order", c.count)
+				}
+				next++
+			}
+		}
+		if !reflect.DeepEqual(sizes, c.sizes) {
+			t.Errorf("%d annotations: expected batches of %v, got %v", c.count, c.sizes, sizes)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const BITBUCKET_CLOUD_URL string = "https://api.bitbucket.org/2.0"
+
+// Posts build statuses and pull request comments via the Bitbucket Cloud 2.0 api
+type BitbucketCloudProvider struct {
+	BaseURL   string
+	Token     string
+	Workspace string
+	Repo      string
+	Client    *http.Client
+}
+
+// Posts build statuses and pull request comments via the Bitbucket Server (and Data Center) 1.0 api
+type BitbucketServerProvider struct {
+	BaseURL string
+	Token   string
+	Project string
+	Repo    string
+	Client  *http.Client
+}
+
+type bitbucketCloudPullRequest struct {
+	ID     int    `json:"id"`
+	State  string `json:"state"`
+	Source struct {
+		Commit struct {
+			Hash string `json:"hash"`
+		} `json:"commit"`
+	} `json:"source"`
+}
+
+type bitbucketCloudComment struct {
+	ID      int  `json:"id"`
+	Deleted bool `json:"deleted"`
+	Content struct {
+		Raw string `json:"raw"`
+	} `json:"content"`
+}
+
+type bitbucketServerPullRequest struct {
+	ID      int    `json:"id"`
+	State   string `json:"state"`
+	FromRef struct {
+		LatestCommit string `json:"latestCommit"`
+	} `json:"fromRef"`
+}
+
+type bitbucketServerComment struct {
+	ID      int    `json:"id"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+// Create a Bitbucket connection to the repo, 'owner' is the workspace on Cloud and the project key on Server
+func newBitbucketProvider() Provider {
+	base_url := strings.TrimSuffix(viper.GetString("bitbucket_url"), "/")
+	if viper.GetBool("bitbucket_server") {
+		return &BitbucketServerProvider{
+			BaseURL: base_url,
+			Token:   viper.GetString("token"),
+			Project: viper.GetString("owner"),
+			Repo:    viper.GetString("repo"),
+			Client:  retryClient(),
+		}
+	}
+	return &BitbucketCloudProvider{
+		BaseURL:   base_url,
+		Token:     viper.GetString("token"),
+		Workspace: viper.GetString("owner"),
+		Repo:      viper.GetString("repo"),
+		Client:    retryClient(),
+	}
+}
+
+// Map the state of a status onto the state of a Bitbucket build status
+func bitbucketState(state string) string {
+	switch state {
+	case "pending":
+		return "INPROGRESS"
+	case "success":
+		return "SUCCESSFUL"
+	default: // failure and error
+		return "FAILED"
+	}
+}
+
+// Authenticate with an access token, or with an app password when the 'token' is formatted as 'username:app_password'
+func bitbucketAuth(token string) func(*http.Request) {
+	return func(req *http.Request) {
+		if parts := strings.SplitN(token, ":", 2); len(parts) == 2 {
+			req.SetBasicAuth(parts[0], parts[1])
+			return
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// Check if a pull request in the Bitbucket state ('OPEN', 'MERGED', 'DECLINED' or 'SUPERSEDED') matches the 'pr_state'
+func bitbucketStateMatches(state string) bool {
+	switch strings.ToLower(viper.GetString("pr_state")) {
+	case "all":
+		return true
+	case "open":
+		return state == "OPEN"
+	default: // closed
+		return state != "OPEN"
+	}
+}
+
+// Send a request to the Bitbucket Cloud api, the path can also be the full url of the next page
+func (p *BitbucketCloudProvider) request(method, path string, body, v interface{}) error {
+	u := path
+	if !strings.HasPrefix(path, "http") {
+		u = fmt.Sprintf("%s/repositories/%s/%s/%s", p.BaseURL, p.Workspace, p.Repo, path)
+	}
+	_, err := apiRequest(p.Client, method, u, bitbucketAuth(p.Token), body, v)
+	return err
+}
+
+// The web url of the instance, derived from its api url (like 'https://api.bitbucket.org/2.0')
+func (p *BitbucketCloudProvider) webURL() string {
+	u, err := url.Parse(p.BaseURL)
+	if err != nil {
+		return ""
+	}
+	u.Host = strings.TrimPrefix(u.Host, "api.")
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/2.0")
+	u.RawPath = ""
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// Bitbucket Cloud requires a url, so the commit is linked if none is passed in
+func (p *BitbucketCloudProvider) CreateStatus(commit, state, desc, context, url string) error {
+	if url == "" {
+		url = fmt.Sprintf("%s/%s/%s/commits/%s", p.webURL(), p.Workspace, p.Repo, commit)
+	}
+	status := map[string]string{
+		"key":         context,
+		"name":        context,
+		"state":       bitbucketState(state),
+		"description": desc,
+		"url":         url,
+	}
+	return p.request("POST", fmt.Sprintf("commit/%s/statuses/build", commit), status, nil)
+}
+
+func (p *BitbucketCloudProvider) PullRequestHead(pr_num int) (string, error) {
+	pr := &bitbucketCloudPullRequest{}
+	err := p.request("GET", fmt.Sprintf("pullrequests/%d", pr_num), nil, pr)
+	if err != nil {
+		return "", err
+	}
+	if pr.Source.Commit.Hash == "" {
+		return "", fmt.Errorf("PR '%d' does not have a head commit", pr_num)
+	}
+	return pr.Source.Commit.Hash, nil
+}
+
+func (p *BitbucketCloudProvider) ResolvePullRequests(commit string) ([]int, error) {
+	prs := []int{}
+	next := fmt.Sprintf("commit/%s/pullrequests?pagelen=50", commit)
+	for next != "" {
+		page := &struct {
+			Values []bitbucketCloudPullRequest `json:"values"`
+			Next   string                      `json:"next"`
+		}{}
+		if err := p.request("GET", next, nil, page); err != nil {
+			return nil, err
+		}
+		for _, pr := range page.Values {
+			if bitbucketStateMatches(pr.State) {
+				prs = append(prs, pr.ID)
+			}
+		}
+		next = page.Next
+	}
+	return prs, nil
+}
+
+func (p *BitbucketCloudProvider) ListComments(pr_num int) ([]Comment, error) {
+	comments := []Comment{}
+	next := fmt.Sprintf("pullrequests/%d/comments?pagelen=100", pr_num)
+	for next != "" {
+		page := &struct {
+			Values []bitbucketCloudComment `json:"values"`
+			Next   string                  `json:"next"`
+		}{}
+		if err := p.request("GET", next, nil, page); err != nil {
+			return nil, err
+		}
+		for _, c := range page.Values {
+			if !c.Deleted {
+				comments = append(comments, Comment{ID: c.ID, Body: c.Content.Raw})
+			}
+		}
+		next = page.Next
+	}
+	return comments, nil
+}
+
+func (p *BitbucketCloudProvider) CreateComment(pr_num int, body string) error {
+	return p.request("POST", fmt.Sprintf("pullrequests/%d/comments", pr_num), bitbucketCloudBody(body), nil)
+}
+
+func (p *BitbucketCloudProvider) EditComment(pr_num, id int, body string) error {
+	return p.request("PUT", fmt.Sprintf("pullrequests/%d/comments/%d", pr_num, id), bitbucketCloudBody(body), nil)
+}
+
+func (p *BitbucketCloudProvider) DeleteComment(pr_num, id int) error {
+	return p.request("DELETE", fmt.Sprintf("pullrequests/%d/comments/%d", pr_num, id), nil, nil)
+}
+
+func bitbucketCloudBody(body string) map[string]map[string]string {
+	return map[string]map[string]string{"content": {"raw": body}}
+}
+
+// Send a request to the Bitbucket Server api of the repo
+func (p *BitbucketServerProvider) request(method, path string, body, v interface{}) error {
+	u := fmt.Sprintf("%s/rest/api/1.0/projects/%s/repos/%s/%s", p.BaseURL, p.Project, p.Repo, path)
+	_, err := apiRequest(p.Client, method, u, bitbucketAuth(p.Token), body, v)
+	return err
+}
+
+// Walk every page of a Bitbucket Server collection, calling 'add' with the raw values of each page
+func (p *BitbucketServerProvider) pages(path string, values interface{}, add func()) error {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	start := 0
+	for {
+		page := &struct {
+			Values        interface{} `json:"values"`
+			IsLastPage    bool        `json:"isLastPage"`
+			NextPageStart int         `json:"nextPageStart"`
+		}{Values: values}
+		if err := p.request("GET", fmt.Sprintf("%s%slimit=100&start=%d", path, sep, start), nil, page); err != nil {
+			return err
+		}
+		add()
+		if page.IsLastPage {
+			return nil
+		}
+		start = page.NextPageStart
+	}
+}
+
+// Bitbucket Server requires a url, so the commit is linked if none is passed in
+func (p *BitbucketServerProvider) CreateStatus(commit, state, desc, context, url string) error {
+	if url == "" {
+		url = fmt.Sprintf("%s/projects/%s/repos/%s/commits/%s", p.BaseURL, p.Project, p.Repo, commit)
+	}
+	status := map[string]string{
+		"key":         context,
+		"name":        context,
+		"state":       bitbucketState(state),
+		"description": desc,
+		"url":         url,
+	}
+	// the build status api is not scoped to the repo
+	_, err := apiRequest(p.Client, "POST", fmt.Sprintf("%s/rest/build-status/1.0/commits/%s", p.BaseURL, commit),
+		bitbucketAuth(p.Token), status, nil)
+	return err
+}
+
+func (p *BitbucketServerProvider) PullRequestHead(pr_num int) (string, error) {
+	pr := &bitbucketServerPullRequest{}
+	err := p.request("GET", fmt.Sprintf("pull-requests/%d", pr_num), nil, pr)
+	if err != nil {
+		return "", err
+	}
+	if pr.FromRef.LatestCommit == "" {
+		return "", fmt.Errorf("PR '%d' does not have a head commit", pr_num)
+	}
+	return pr.FromRef.LatestCommit, nil
+}
+
+func (p *BitbucketServerProvider) ResolvePullRequests(commit string) ([]int, error) {
+	prs := []int{}
+	values := []bitbucketServerPullRequest{}
+	err := p.pages(fmt.Sprintf("commits/%s/pull-requests", commit), &values, func() {
+		for _, pr := range values {
+			if bitbucketStateMatches(pr.State) {
+				prs = append(prs, pr.ID)
+			}
+		}
+		values = values[:0]
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prs, nil
+}
+
+// The comments are found in the activities of the pull request, which are listed newest first
+func (p *BitbucketServerProvider) ListComments(pr_num int) ([]Comment, error) {
+	comments := []Comment{}
+	values := []struct {
+		Action  string                  `json:"action"`
+		Comment *bitbucketServerComment `json:"comment"`
+	}{}
+	err := p.pages(fmt.Sprintf("pull-requests/%d/activities", pr_num), &values, func() {
+		for _, a := range values {
+			if a.Action == "COMMENTED" && a.Comment != nil {
+				comments = append([]Comment{{ID: a.Comment.ID, Body: a.Comment.Text}}, comments...)
+			}
+		}
+		values = values[:0]
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func (p *BitbucketServerProvider) CreateComment(pr_num int, body string) error {
+	return p.request("POST", fmt.Sprintf("pull-requests/%d/comments", pr_num), map[string]string{"text": body}, nil)
+}
+
+// Bitbucket Server only edits the latest version of a comment, so the version is looked up first
+func (p *BitbucketServerProvider) EditComment(pr_num, id int, body string) error {
+	c, err := p.comment(pr_num, id)
+	if err != nil {
+		return err
+	}
+	return p.request("PUT", fmt.Sprintf("pull-requests/%d/comments/%d", pr_num, id), map[string]interface{}{
+		"text":    body,
+		"version": c.Version,
+	}, nil)
+}
+
+func (p *BitbucketServerProvider) DeleteComment(pr_num, id int) error {
+	c, err := p.comment(pr_num, id)
+	if err != nil {
+		return err
+	}
+	return p.request("DELETE", fmt.Sprintf("pull-requests/%d/comments/%d?version=%d", pr_num, id, c.Version), nil, nil)
+}
+
+func (p *BitbucketServerProvider) comment(pr_num, id int) (*bitbucketServerComment, error) {
+	c := &bitbucketServerComment{}
+	err := p.request("GET", fmt.Sprintf("pull-requests/%d/comments/%d", pr_num, id), nil, c)
+	return c, err
+}
+
+// Check the Bitbucket specific flags, returns the errors to display
+func bitbucketCheckUsage() string {
+	invalid := ""
+	bitbucket_url := viper.GetString("bitbucket_url")
+	if _, err := url.Parse(bitbucket_url); err != nil {
+		invalid += fmt.Sprintf("ERROR: The 'bitbucket_url' flag is not a valid url: %s\n", err.Error())
+	}
+	if viper.GetBool("bitbucket_server") && strings.TrimSuffix(bitbucket_url, "/") == BITBUCKET_CLOUD_URL {
+		invalid += "ERROR: The 'bitbucket_url' flag is required when using 'bitbucket_server'\n"
+	}
+	return invalid
+}
@@ -0,0 +1,259 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// A request received by the fake Bitbucket api
+type bitbucketRequest struct {
+	Method string
+	URI    string // with the query, so the paging can be checked
+	Auth   string
+	Body   map[string]interface{}
+}
+
+// A stand in for the Bitbucket Cloud or Server api, serving the pages of the collections by path
+type fakeBitbucket struct {
+	server   bool
+	pages    map[string][][]interface{}
+	objects  map[string]interface{}
+	requests []bitbucketRequest
+}
+
+func (f *fakeBitbucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := bitbucketRequest{Method: r.Method, URI: r.URL.RequestURI(), Auth: r.Header.Get("Authorization")}
+	data, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(data, &req.Body)
+	f.requests = append(f.requests, req)
+
+	if pages, ok := f.pages[r.URL.Path]; ok && r.Method == "GET" {
+		// Cloud links the next page by url, Server by an opaque start
+		param := "page"
+		if f.server {
+			param = "start"
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get(param))
+		values := map[string]interface{}{"values": pages[page]}
+		if f.server {
+			values["isLastPage"] = page == len(pages)-1
+			values["nextPageStart"] = page + 1
+		} else if page < len(pages)-1 {
+			values["next"] = fmt.Sprintf("http://%s%s?page=%d", r.Host, r.URL.Path, page+1)
+		}
+		json.NewEncoder(w).Encode(values)
+		return
+	}
+	if object, ok := f.objects[r.URL.Path]; ok && r.Method == "GET" {
+		json.NewEncoder(w).Encode(object)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("{}"))
+}
+
+func newTestBitbucketCloudProvider(srv *httptest.Server) *BitbucketCloudProvider {
+	return &BitbucketCloudProvider{BaseURL: srv.URL + "/2.0", Token: "secret", Workspace: "owner", Repo: "repo", Client: retryClient()}
+}
+
+func newTestBitbucketServerProvider(srv *httptest.Server) *BitbucketServerProvider {
+	return &BitbucketServerProvider{BaseURL: srv.URL, Token: "secret", Project: "PROJ", Repo: "repo", Client: retryClient()}
+}
+
+func TestBitbucketCloudWebURL(t *testing.T) {
+	cases := map[string]string{
+		"https://api.bitbucket.org/2.0":      "https://bitbucket.org",
+		"https://api.bitbucket.org/2.0/":     "https://bitbucket.org",
+		"http://127.0.0.1:8080/2.0":          "http://127.0.0.1:8080",
+		"https://proxy.example.com/bb/2.0/":  "https://proxy.example.com/bb",
+		"https://api.example.com/bitbucket/": "https://example.com/bitbucket",
+	}
+	for base_url, expected := range cases {
+		p := &BitbucketCloudProvider{BaseURL: base_url}
+		if web_url := p.webURL(); web_url != expected {
+			t.Errorf("expected the web url of '%s' to be '%s', got '%s'", base_url, expected, web_url)
+		}
+	}
+}
+
+func TestBitbucketCloudCreateStatus(t *testing.T) {
+	f := &fakeBitbucket{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	p := newTestBitbucketCloudProvider(srv)
+
+	if err := p.CreateStatus("abc123", "pending", "Running", "ci/build", "https://ci.example.com/1"); err != nil {
+		t.Fatal(err)
+	}
+	p.Token = "user:app_password"
+	if err := p.CreateStatus("abc123", "error", "Could not run", "ci/build", ""); err != nil {
+		t.Fatal(err)
+	}
+	expected := []bitbucketRequest{
+		{"POST", "/2.0/repositories/owner/repo/commit/abc123/statuses/build", "Bearer secret", map[string]interface{}{
+			"key": "ci/build", "name": "ci/build", "state": "INPROGRESS", "description": "Running", "url": "https://ci.example.com/1"}},
+		{"POST", "/2.0/repositories/owner/repo/commit/abc123/statuses/build", "Basic dXNlcjphcHBfcGFzc3dvcmQ=", map[string]interface{}{
+			"key": "ci/build", "name": "ci/build", "state": "FAILED", "description": "Could not run",
+			"url": srv.URL + "/owner/repo/commits/abc123"}},
+	}
+	if !reflect.DeepEqual(f.requests, expected) {
+		t.Errorf("expected the requests %v, got %v", expected, f.requests)
+	}
+}
+
+func TestBitbucketCloudListComments(t *testing.T) {
+	f := &fakeBitbucket{pages: map[string][][]interface{}{
+		"/2.0/repositories/owner/repo/pullrequests/3/comments": {
+			{map[string]interface{}{"id": 1, "content": map[string]string{"raw": "first"}}},
+			{
+				map[string]interface{}{"id": 2, "deleted": true, "content": map[string]string{"raw": ""}},
+				map[string]interface{}{"id": 3, "content": map[string]string{"raw": "second"}},
+			},
+		},
+	}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	comments, err := newTestBitbucketCloudProvider(srv).ListComments(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Comment{{ID: 1, Body: "first"}, {ID: 3, Body: "second"}}
+	if !reflect.DeepEqual(comments, expected) {
+		t.Errorf("expected the comments %v, got %v", expected, comments)
+	}
+	if len(f.requests) != 2 || f.requests[1].URI != "/2.0/repositories/owner/repo/pullrequests/3/comments?page=1" {
+		t.Errorf("expected the second page to be requested by its next url, got %v", f.requests)
+	}
+}
+
+func TestBitbucketCloudResolvePullRequests(t *testing.T) {
+	f := &fakeBitbucket{pages: map[string][][]interface{}{
+		"/2.0/repositories/owner/repo/commit/abc123/pullrequests": {
+			{map[string]interface{}{"id": 1, "state": "OPEN"}, map[string]interface{}{"id": 2, "state": "MERGED"}},
+			{map[string]interface{}{"id": 3, "state": "OPEN"}, map[string]interface{}{"id": 4, "state": "DECLINED"}},
+		},
+	}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	cases := map[string][]int{"open": {1, 3}, "closed": {2, 4}, "all": {1, 2, 3, 4}}
+	for pr_state, expected := range cases {
+		setConfig(t, "pr_state", pr_state)
+		prs, err := newTestBitbucketCloudProvider(srv).ResolvePullRequests("abc123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(prs, expected) {
+			t.Errorf("%s: expected the PRs %v, got %v", pr_state, expected, prs)
+		}
+	}
+}
+
+func TestBitbucketServerCreateStatus(t *testing.T) {
+	f := &fakeBitbucket{server: true}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	if err := newTestBitbucketServerProvider(srv).CreateStatus("abc123", "success", "Passed", "ci/build", ""); err != nil {
+		t.Fatal(err)
+	}
+	expected := []bitbucketRequest{
+		{"POST", "/rest/build-status/1.0/commits/abc123", "Bearer secret", map[string]interface{}{
+			"key": "ci/build", "name": "ci/build", "state": "SUCCESSFUL", "description": "Passed",
+			"url": srv.URL + "/projects/PROJ/repos/repo/commits/abc123"}},
+	}
+	if !reflect.DeepEqual(f.requests, expected) {
+		t.Errorf("expected the requests %v, got %v", expected, f.requests)
+	}
+}
+
+func TestBitbucketServerListComments(t *testing.T) {
+	// the activities are listed newest first
+	f := &fakeBitbucket{server: true, pages: map[string][][]interface{}{
+		"/rest/api/1.0/projects/PROJ/repos/repo/pull-requests/3/activities": {
+			{
+				map[string]interface{}{"action": "COMMENTED", "comment": map[string]interface{}{"id": 3, "text": "third"}},
+				map[string]interface{}{"action": "APPROVED"},
+			},
+			{
+				map[string]interface{}{"action": "COMMENTED", "comment": map[string]interface{}{"id": 2, "text": "second"}},
+				map[string]interface{}{"action": "COMMENTED", "comment": map[string]interface{}{"id": 1, "text": "first"}},
+			},
+		},
+	}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	comments, err := newTestBitbucketServerProvider(srv).ListComments(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Comment{{ID: 1, Body: "first"}, {ID: 2, Body: "second"}, {ID: 3, Body: "third"}}
+	if !reflect.DeepEqual(comments, expected) {
+		t.Errorf("expected the comments %v, got %v", expected, comments)
+	}
+	if len(f.requests) != 2 || f.requests[1].URI != "/rest/api/1.0/projects/PROJ/repos/repo/pull-requests/3/activities?limit=100&start=1" {
+		t.Errorf("expected the second page to be requested from its start, got %v", f.requests)
+	}
+}
+
+func TestBitbucketServerResolvePullRequests(t *testing.T) {
+	setConfig(t, "pr_state", "closed")
+	f := &fakeBitbucket{server: true, pages: map[string][][]interface{}{
+		"/rest/api/1.0/projects/PROJ/repos/repo/commits/abc123/pull-requests": {
+			{map[string]interface{}{"id": 1, "state": "OPEN"}},
+			{map[string]interface{}{"id": 2, "state": "MERGED"}},
+		},
+	}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	prs, err := newTestBitbucketServerProvider(srv).ResolvePullRequests("abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prs, []int{2}) {
+		t.Errorf("expected the PRs [2], got %v", prs)
+	}
+}
+
+func TestBitbucketServerEditComment(t *testing.T) {
+	f := &fakeBitbucket{server: true, objects: map[string]interface{}{
+		"/rest/api/1.0/projects/PROJ/repos/repo/pull-requests/3/comments/7": map[string]interface{}{"id": 7, "version": 2, "text": "old"},
+	}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	if err := newTestBitbucketServerProvider(srv).EditComment(3, 7, "new"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []bitbucketRequest{
+		{"GET", "/rest/api/1.0/projects/PROJ/repos/repo/pull-requests/3/comments/7", "Bearer secret", nil},
+		{"PUT", "/rest/api/1.0/projects/PROJ/repos/repo/pull-requests/3/comments/7", "Bearer secret", map[string]interface{}{
+			"text": "new", "version": float64(2)}},
+	}
+	if !reflect.DeepEqual(f.requests, expected) {
+		t.Errorf("expected the requests %v, got %v", expected, f.requests)
+	}
+}
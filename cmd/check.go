@@ -0,0 +1,453 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	QUEUED      string = "queued"
+	IN_PROGRESS string = "in_progress"
+	COMPLETED   string = "completed"
+)
+
+type CheckRun struct {
+	ID          int             `json:"id,omitempty"`
+	Name        string          `json:"name,omitempty"`
+	HeadSHA     string          `json:"head_sha,omitempty"`
+	DetailsURL  string          `json:"details_url,omitempty"`
+	Status      string          `json:"status,omitempty"`
+	Conclusion  string          `json:"conclusion,omitempty"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+	Output      *CheckRunOutput `json:"output,omitempty"`
+}
+
+type CheckRunOutput struct {
+	Title       string               `json:"title"`
+	Summary     string               `json:"summary"`
+	Text        string               `json:"text,omitempty"`
+	Annotations []CheckRunAnnotation `json:"annotations,omitempty"`
+}
+
+type CheckRunAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Message         string `json:"message"`
+}
+
+type CheckBody struct {
+	CommitID string
+	Title    string
+	Summary  string
+}
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Create or update a check run on Github.",
+	Long: `Create or update a check run on Github.
+
+This command allows an arbitrary CI implementation to
+post rich results of its run to the Checks tab of the
+pull request related to the commit the CI was run against.
+
+A new check run is created on 'commit' and/or the head
+commit of 'pr_num' and its id is printed, unless 'check_id'
+isset, in which case that check run is updated.`,
+}
+
+func init() {
+	RootCmd.AddCommand(checkCmd)
+
+	checkCmd.Run = check
+	checkCmd.PreRun = checkBindFlags
+	checkCmd.Flags().StringP("context", "x", "", "required unless 'check_id' isset: the name of the check run")
+	checkCmd.Flags().Int("check_id", 0, "optional: the id of an existing check run to update")
+	checkCmd.Flags().String("status", "", fmt.Sprintf(
+		"optional: check run status (%s | %s | %s)", QUEUED, IN_PROGRESS, COMPLETED))
+	checkCmd.Flags().String("conclusion", "", "required if 'status' is completed: check run conclusion (success | failure | neutral | cancelled | skipped | timed_out | action_required)")
+	checkCmd.Flags().StringP("title", "t", "", "optional: the title of the check run output")
+	checkCmd.Flags().StringP("summary_file", "f", "", "optional: file which includes the markdown summary (or piped stdin)")
+	checkCmd.Flags().String("text_file", "", "optional: file which includes the markdown details of the check run")
+	checkCmd.Flags().StringSliceP("annotation", "a", []string{}, "optional: line annotation as 'path:line:level:message' where level is (notice | warning | failure)")
+	checkCmd.Flags().StringP("url", "u", "", "optional: a reference url for more information about this check run")
+	checkCmd.Flags().String("annotations_file", "", "optional: file (or '-' for stdin) of compiler or linter output to convert into annotations")
+	checkCmd.Flags().String("annotations_format", AUTO, fmt.Sprintf(
+		"optional: format of the 'annotations_file' (%s | %s | %s | %s | %s | %s)", AUTO, GCC, GOVET, GOLANGCI, ESLINT, CHECKSTYLE))
+}
+
+// The 'check' flags share names with other commands, so only bind them when 'check' runs
+func checkBindFlags(cmd *cobra.Command, args []string) {
+	viper.BindPFlag("context", checkCmd.Flags().Lookup("context"))
+	viper.BindPFlag("check_id", checkCmd.Flags().Lookup("check_id"))
+	viper.BindPFlag("check_status", checkCmd.Flags().Lookup("status"))
+	viper.BindPFlag("conclusion", checkCmd.Flags().Lookup("conclusion"))
+	viper.BindPFlag("title", checkCmd.Flags().Lookup("title"))
+	viper.BindPFlag("file", checkCmd.Flags().Lookup("summary_file"))
+	viper.BindPFlag("text_file", checkCmd.Flags().Lookup("text_file"))
+	viper.BindPFlag("url", checkCmd.Flags().Lookup("url"))
+	viper.BindPFlag("annotations_file", checkCmd.Flags().Lookup("annotations_file"))
+	viper.BindPFlag("annotations_format", checkCmd.Flags().Lookup("annotations_format"))
+}
+
+// The 'annotation' flags are read from the flag set, viper does not decode the values of a string slice flag
+func checkAnnotationFlags() []string {
+	annotations, _ := checkCmd.Flags().GetStringSlice("annotation")
+	return annotations
+}
+
+func checkCheckUsage() {
+	// check if a string is in a list
+	in := func(list []string, a string) bool {
+		for _, b := range list {
+			if b == a {
+				return true
+			}
+		}
+		return false
+	}
+	missing := []string{}
+	usage := ""
+	invalid := ""
+
+	// check runs can only be created with the installation token of a Github App, not with a personal 'token'
+	if !viper.IsSet("app_id") {
+		if viper.IsSet("token") {
+			invalid += "ERROR: Check runs can only be created by a Github App, use the 'app_id', 'installation_id' and 'app_key_file' flags instead of the 'token' flag\n"
+		} else {
+			missing = append(missing, "app_id")
+		}
+	}
+	if !viper.IsSet("owner") {
+		missing = append(missing, "owner")
+	}
+	if !viper.IsSet("repo") {
+		missing = append(missing, "repo")
+	}
+	if !viper.IsSet("check_id") {
+		if !viper.IsSet("commit") && !viper.IsSet("pr_num") {
+			missing = append(missing, "(commit || pr_num)")
+		}
+		if !viper.IsSet("context") {
+			missing = append(missing, "context")
+		}
+	}
+
+	if viper.IsSet("check_status") {
+		status := strings.ToLower(viper.GetString("check_status"))
+		statuses := []string{QUEUED, IN_PROGRESS, COMPLETED}
+		if !in(statuses, status) {
+			invalid += fmt.Sprintf("ERROR: The 'status' flag must be one of: %s\n", strings.Join(statuses, ", "))
+		}
+		if status == COMPLETED && !viper.IsSet("conclusion") {
+			missing = append(missing, "conclusion")
+		}
+	}
+	if viper.IsSet("conclusion") {
+		conclusion := strings.ToLower(viper.GetString("conclusion"))
+		conclusions := []string{"success", "failure", "neutral", "cancelled", "skipped", "timed_out", "action_required"}
+		if !in(conclusions, conclusion) {
+			invalid += fmt.Sprintf("ERROR: The 'conclusion' flag must be one of: %s\n", strings.Join(conclusions, ", "))
+		}
+	}
+	for _, a := range checkAnnotationFlags() {
+		if _, err := parseAnnotation(a); err != nil {
+			invalid += fmt.Sprintf("ERROR: The 'annotation' flag %s\n", err.Error())
+		}
+	}
+	if viper.IsSet("annotations_format") {
+		format := strings.ToLower(viper.GetString("annotations_format"))
+		formats := []string{AUTO, GCC, GOVET, GOLANGCI, ESLINT, CHECKSTYLE}
+		if !in(formats, format) {
+			invalid += fmt.Sprintf("ERROR: The 'annotations_format' flag must be one of: %s\n", strings.Join(formats, ", "))
+		}
+	}
+	invalid += resolveCheckUsage()
+	invalid += clientCheckUsage()
+	if strings.ToLower(viper.GetString("provider")) != GITHUB {
+		invalid += fmt.Sprintf("ERROR: Check runs are only supported by the '%s' provider\n", GITHUB)
+	}
+
+	if len(missing) > 0 {
+		usage += fmt.Sprintf("MISSING REQUIRED FLAGS: %s\n", strings.Join(missing, ", "))
+	}
+
+	usage += invalid
+	if usage != "" {
+		fmt.Printf("\n%s\n", usage)
+		checkCmd.Help()
+		os.Exit(-1)
+	}
+}
+
+// Create or update a check run on Github
+func check(cmd *cobra.Command, args []string) {
+	checkCheckUsage()
+	owner := viper.GetString("owner")
+	repo := viper.GetString("repo")
+	check_id := viper.GetInt("check_id")
+	status := strings.ToLower(viper.GetString("check_status"))
+	conclusion := strings.ToLower(viper.GetString("conclusion"))
+
+	loadTemplates()
+	gh := githubClient()
+
+	run := &CheckRun{
+		Name:       viper.GetString("context"),
+		DetailsURL: viper.GetString("url"),
+		Status:     status,
+		Conclusion: conclusion,
+	}
+	now := time.Now().UTC()
+	if status == IN_PROGRESS {
+		run.StartedAt = &now
+	}
+	if status == COMPLETED || (status == "" && conclusion != "") {
+		run.CompletedAt = &now
+	}
+
+	// the output of the check run is only included if something was passed in
+	output, err := checkOutput()
+	if err != nil {
+		log.Printf("ERROR: %s\n", err.Error())
+		os.Exit(-1)
+	}
+
+	if check_id != 0 {
+		if output != nil {
+			run.Output = renderCheckOutput(output, "")
+		}
+		_, err := sendCheckRun(gh, owner, repo, check_id, run)
+		if err != nil {
+			log.Printf("ERROR updating check run '%d': %s\n", check_id, err.Error())
+			os.Exit(-1)
+		}
+		log.Println("Successfully updated the check run!")
+		return
+	}
+
+	commits, err := ResolveCommits(newGithubProvider(gh))
+	if err != nil {
+		log.Printf("ERROR: %s\n", err.Error())
+		os.Exit(-1)
+	}
+	for _, commit := range commits {
+		run.HeadSHA = commit
+		if output != nil {
+			run.Output = renderCheckOutput(output, commit)
+		}
+		created_id, err := sendCheckRun(gh, owner, repo, 0, run)
+		if err != nil {
+			log.Printf("ERROR creating check run on commit '%s': %s\n", commit, err.Error())
+			os.Exit(-1)
+		}
+		log.Printf("Successfully created check run '%d' on commit '%s'!\n", created_id, commit)
+		fmt.Println(created_id)
+	}
+}
+
+// Build the output of the check run from the title, summary, text and annotations, nil if none are passed in
+func checkOutput() (*CheckRunOutput, error) {
+	output := &CheckRunOutput{
+		Title: viper.GetString("title"),
+	}
+
+	var summary []byte
+	if viper.IsSet("file") {
+		var err error
+		summary, err = ioutil.ReadFile(viper.GetString("file"))
+		if err != nil {
+			return nil, fmt.Errorf("reading summary_file '%s': %s", viper.GetString("file"), err.Error())
+		}
+	}
+	// stdin is reserved for the annotations if the 'annotations_file' is '-'
+	if stdinPiped() && viper.GetString("annotations_file") != "-" {
+		piped, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading stdin: %s", err.Error())
+		}
+		if len(summary) > 0 && len(piped) > 0 {
+			summary = append(summary, []byte("\n\n")...)
+		}
+		summary = append(summary, piped...)
+	}
+	output.Summary = string(summary)
+
+	if viper.IsSet("text_file") {
+		text, err := ioutil.ReadFile(viper.GetString("text_file"))
+		if err != nil {
+			return nil, fmt.Errorf("reading text_file '%s': %s", viper.GetString("text_file"), err.Error())
+		}
+		output.Text = string(text)
+	}
+
+	for _, a := range checkAnnotationFlags() {
+		annotation, err := parseAnnotation(a)
+		if err != nil {
+			return nil, err
+		}
+		output.Annotations = append(output.Annotations, *annotation)
+	}
+	if viper.IsSet("annotations_file") {
+		annotations, err := readAnnotations(viper.GetString("annotations_file"),
+			strings.ToLower(viper.GetString("annotations_format")))
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Parsed %d annotations from '%s'.\n", len(annotations), viper.GetString("annotations_file"))
+		output.Annotations = append(output.Annotations, annotations...)
+	}
+
+	if output.Title == "" && output.Summary == "" && output.Text == "" && len(output.Annotations) == 0 {
+		return nil, nil
+	}
+	if output.Title == "" {
+		output.Title = viper.GetString("context")
+	}
+	return output, nil
+}
+
+// Render the summary of the output through the 'check_summary' template
+func renderCheckOutput(output *CheckRunOutput, commit string) *CheckRunOutput {
+	var buf bytes.Buffer
+	err := templates.ExecuteTemplate(&buf, "check_summary", &CheckBody{
+		CommitID: commit,
+		Title:    output.Title,
+		Summary:  output.Summary,
+	})
+	if err != nil {
+		log.Printf("ERROR executing template: %s\n", err.Error())
+		os.Exit(-1)
+	}
+	rendered := *output
+	rendered.Summary = buf.String()
+	return &rendered
+}
+
+// Parse an annotation formatted as 'path:line:level:message'
+func parseAnnotation(a string) (*CheckRunAnnotation, error) {
+	levels := []string{"notice", "warning", "failure"}
+	parts := strings.SplitN(a, ":", 4)
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("'%s' is not formatted as 'path:line:level:message'", a)
+	}
+	line, err := strconv.Atoi(parts[1])
+	if err != nil || line < 1 {
+		return nil, fmt.Errorf("'%s' does not have a valid line number", a)
+	}
+	level := strings.ToLower(parts[2])
+	valid := false
+	for _, l := range levels {
+		if l == level {
+			valid = true
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("'%s' level must be one of: %s", a, strings.Join(levels, ", "))
+	}
+	return &CheckRunAnnotation{
+		Path:            parts[0],
+		StartLine:       line,
+		EndLine:         line,
+		AnnotationLevel: level,
+		Message:         strings.TrimSpace(parts[3]),
+	}, nil
+}
+
+// Check if data is being piped in on stdin (as opposed to an interactive terminal)
+func stdinPiped() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice == 0
+}
+
+// The Checks API is only available with the 'antiope' preview
+func checksRequest(gh *github.Client, method, u string, body interface{}) (*CheckRun, error) {
+	req, err := gh.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.antiope-preview+json")
+
+	run := &CheckRun{}
+	_, err = gh.Do(req, run)
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// Create (if 'id' is 0) or update a check run, sending the annotations in batches Github will accept
+func sendCheckRun(gh *github.Client, owner, repo string, id int, run *CheckRun) (int, error) {
+	batches := [][]CheckRunAnnotation{nil}
+	first := *run
+	if run.Output != nil {
+		batches = annotationBatches(run.Output.Annotations)
+		output := *run.Output
+		output.Annotations = batches[0]
+		first.Output = &output
+	}
+
+	if id == 0 {
+		created, err := createCheckRun(gh, owner, repo, &first)
+		if err != nil {
+			return 0, err
+		}
+		id = created.ID
+	} else {
+		err := updateCheckRun(gh, owner, repo, id, &first)
+		if err != nil {
+			return id, err
+		}
+	}
+
+	// Github appends the annotations of every update to the check run
+	for _, batch := range batches[1:] {
+		output := *run.Output
+		output.Annotations = batch
+		err := updateCheckRun(gh, owner, repo, id, &CheckRun{Output: &output})
+		if err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+// Create a check run on the 'HeadSHA' of the run
+func createCheckRun(gh *github.Client, owner, repo string, run *CheckRun) (*CheckRun, error) {
+	u := fmt.Sprintf("repos/%s/%s/check-runs", owner, repo)
+	return checksRequest(gh, "POST", u, run)
+}
+
+// Update an existing check run
+func updateCheckRun(gh *github.Client, owner, repo string, id int, run *CheckRun) error {
+	u := fmt.Sprintf("repos/%s/%s/check-runs/%d", owner, repo, id)
+	_, err := checksRequest(gh, "PATCH", u, run)
+	return err
+}
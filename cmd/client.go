@@ -0,0 +1,274 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/viper"
+)
+
+// A token source which mints installation tokens for a Github App
+type appTokenSource struct {
+	AppID          int
+	InstallationID int
+	Key            *rsa.PrivateKey
+	BaseURL        *url.URL
+	Client         *http.Client
+}
+
+// Validate the flags used to connect to Github, returns the errors to display
+func clientCheckUsage() string {
+	invalid := ""
+	if viper.IsSet("app_id") && (!viper.IsSet("installation_id") || !viper.IsSet("app_key_file")) {
+		invalid += "ERROR: The 'app_id' flag requires the 'installation_id' and 'app_key_file' flags\n"
+	}
+	if viper.IsSet("github_cert_file") != viper.IsSet("github_key_file") {
+		invalid += "ERROR: The 'github_cert_file' and 'github_key_file' flags must be used together\n"
+	}
+	if limit := viper.GetString("rate_limit"); limit != WAIT && limit != FAIL {
+		invalid += fmt.Sprintf("ERROR: The 'rate_limit' flag must be one of: %s, %s\n", WAIT, FAIL)
+	}
+	for _, key := range []string{"github_url", "github_upload_url"} {
+		if viper.IsSet(key) {
+			u, err := url.Parse(viper.GetString(key))
+			if err != nil {
+				invalid += fmt.Sprintf("ERROR: The '%s' flag is not a valid url: %s\n", key, err.Error())
+			} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				invalid += fmt.Sprintf("ERROR: The '%s' flag must be an absolute http or https url\n", key)
+			}
+		}
+	}
+	return invalid
+}
+
+// Create a Github connection authenticated via the 'token' or as a Github App, optionally to a Github Enterprise instance
+func githubClient() *github.Client {
+	base, err := githubTransport()
+	if err != nil {
+		log.Printf("ERROR: %s\n", err.Error())
+		os.Exit(-1)
+	}
+	base = &retryTransport{Base: &rateLimitTransport{Base: base}}
+
+	var ts oauth2.TokenSource
+	if viper.IsSet("app_id") {
+		ts, err = appTokenSourceFromConfig(base)
+		if err != nil {
+			log.Printf("ERROR: %s\n", err.Error())
+			os.Exit(-1)
+		}
+	} else {
+		ts = oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: viper.GetString("token")},
+		)
+	}
+	tc := &http.Client{
+		Transport: &oauth2.Transport{Source: ts, Base: base},
+	}
+	gh := github.NewClient(tc)
+
+	// point the client to a Github Enterprise instance
+	if viper.IsSet("github_url") {
+		gh.BaseURL = githubURL(viper.GetString("github_url"), "v3")
+		gh.UploadURL = githubURL(viper.GetString("github_url"), "uploads")
+	}
+	if viper.IsSet("github_upload_url") {
+		gh.UploadURL = githubURL(viper.GetString("github_upload_url"), "uploads")
+	}
+	return gh
+}
+
+// Github Enterprise serves its api under '/api/v3/' and uploads under '/api/uploads/'.
+// The url is used as is if it already includes an '/api/' path (other than the api path for uploads).
+func githubURL(raw, endpoint string) *url.URL {
+	u, _ := url.Parse(raw) // validated in 'clientCheckUsage'
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	if endpoint == "uploads" && strings.HasSuffix(u.Path, "/api/v3/") {
+		u.Path = strings.TrimSuffix(u.Path, "v3/") + "uploads/"
+	}
+	if !strings.Contains(u.Path, "/api/") {
+		u.Path += fmt.Sprintf("api/%s/", endpoint)
+	}
+	return u
+}
+
+// The transport used to connect to Github, with a custom CA bundle and client certificate if they are set
+func githubTransport() (http.RoundTripper, error) {
+	if !viper.IsSet("github_ca_file") && !viper.IsSet("github_cert_file") {
+		return http.DefaultTransport, nil
+	}
+
+	tls_config := &tls.Config{}
+	if viper.IsSet("github_ca_file") {
+		ca_file := viper.GetString("github_ca_file")
+		pem, err := ioutil.ReadFile(ca_file)
+		if err != nil {
+			return nil, fmt.Errorf("reading github_ca_file '%s': %s", ca_file, err.Error())
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in github_ca_file '%s'", ca_file)
+		}
+		tls_config.RootCAs = pool
+	}
+	if viper.IsSet("github_cert_file") {
+		cert, err := tls.LoadX509KeyPair(viper.GetString("github_cert_file"), viper.GetString("github_key_file"))
+		if err != nil {
+			return nil, fmt.Errorf("loading github_cert_file and github_key_file: %s", err.Error())
+		}
+		tls_config.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tls_config,
+	}, nil
+}
+
+// Create a token source for the Github App 'app_id' installed as 'installation_id', the token is refreshed when it expires
+func appTokenSourceFromConfig(base http.RoundTripper) (oauth2.TokenSource, error) {
+	key_file := viper.GetString("app_key_file")
+	data, err := ioutil.ReadFile(key_file)
+	if err != nil {
+		return nil, fmt.Errorf("reading app_key_file '%s': %s", key_file, err.Error())
+	}
+	key, err := parseRSAKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing app_key_file '%s': %s", key_file, err.Error())
+	}
+
+	base_url, _ := url.Parse("https://api.github.com/")
+	if viper.IsSet("github_url") {
+		base_url = githubURL(viper.GetString("github_url"), "v3")
+	}
+	return oauth2.ReuseTokenSource(nil, &appTokenSource{
+		AppID:          viper.GetInt("app_id"),
+		InstallationID: viper.GetInt("installation_id"),
+		Key:            key,
+		BaseURL:        base_url,
+		Client:         &http.Client{Transport: base},
+	}), nil
+}
+
+// Exchange a JWT signed with the private key of the Github App for an installation token
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.JWT(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	u, _ := s.BaseURL.Parse(fmt.Sprintf("app/installations/%d/access_tokens", s.InstallationID))
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github.machine-man-preview+json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("getting an installation token for app '%d': %s %s", s.AppID, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	installation_token := &struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(installation_token); err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: installation_token.Token,
+		TokenType:   "token",
+		Expiry:      installation_token.ExpiresAt,
+	}, nil
+}
+
+// Mint a JWT identifying the Github App, valid for 9 minutes (Github allows at most 10)
+func (s *appTokenSource) JWT(now time.Time) (string, error) {
+	encode := func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b), err
+	}
+	header, err := encode(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := encode(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(), // allow for clock drift
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": int64(s.AppID),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + claims
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Parse a PEM encoded RSA private key in either the PKCS1 (as downloaded from Github) or PKCS8 format
+func parseRSAKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the key is not an RSA private key")
+	}
+	return key, nil
+}
@@ -21,47 +21,41 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"path/filepath"
+	"regexp"
 	"strings"
-	"sync"
 	"text/template"
 	"time"
 
-	"golang.org/x/oauth2"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/google/go-github/github"
-	"github.com/ncw/swift"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/swill/upr/assets"
 )
 
 const (
-	S3    string = "s3"
-	SWIFT string = "swift"
+	COLLAPSE string = "collapse"
+	DELETE   string = "delete"
 )
 
 var (
 	templates *template.Template
 	stdin     []byte
+
+	commit_marker = regexp.MustCompile(`<!-- upr-commit:(\w+) -->`)
 )
 
-type Upload struct {
-	Name string
-	Path string
-	Obj  string
-	URL  string
+type OutdatedBody struct {
+	CommitID string
+	Body     string
 }
 
 type CommentBody struct {
-	CommitID      string
-	Title         string
-	Summary       string
-	Uploads       map[string][]Upload
-	UploadsExpire *time.Time // pointers can be nil, for template conditional
+	Key            string // identifies related comments via a hidden marker
+	CommitID       string
+	Title          string
+	Summary        string
+	TestResults    *TestResults // pointers can be nil, for template conditional
+	Uploads        map[string][]Upload
+	UploadsExpire  *time.Time // pointers can be nil, for template conditional
+	UploadsPrivate bool       // the upload urls are signed and stop working at UploadsExpire
 }
 
 // commentCmd represents the comment command
@@ -74,41 +68,30 @@ This command allows an arbitrary CI implementation to
 post a comment to a pull request issue thread.
 
 Optionally, files can be made public by uploading them to
-an object store using either the Swift or S3 API.`,
+an object store via the 'uploads_api'.`,
 }
 
 func init() {
 	RootCmd.AddCommand(commentCmd)
 
 	commentCmd.Run = comment
-	commentCmd.Flags().IntP("pr_num", "n", 0, "required unless 'commit' isset: pull request number on which to comment on")
 	commentCmd.Flags().StringP("comment_file", "f", "", "required unless piped stdin: file which includes the comment text")
 	commentCmd.Flags().StringP("title", "t", "", "optional: the title of the comment")
+	commentCmd.Flags().String("sticky", "", "optional: update the existing comment with this key instead of posting a new one")
+	commentCmd.Flags().String("supersede", "", "optional: supersede the existing comments with this key when posting a new one")
+	commentCmd.Flags().String("supersede_mode", COLLAPSE, fmt.Sprintf(
+		"optional: how superseded comments are handled (%s | %s)", COLLAPSE, DELETE))
+	commentCmd.Flags().String("junit", "", "optional: comma separated list of JUnit XML files or globs to summarize in the comment")
 	commentCmd.Flags().StringP("uploads", "u", "", "optional: comma separated list of files or directories to be recusively uploaded")
-	commentCmd.Flags().String("uploads_api", "", fmt.Sprintf(
-		"required if 'uploads' isset: api to use to upload to an object store (%s | %s)", S3, SWIFT))
-	commentCmd.Flags().String("uploads_endpoint", "", "required if 'uploads' isset: object store url endpoint")
-	commentCmd.Flags().String("uploads_region", "", fmt.Sprintf(
-		"upload region when using the '%s' api", S3))
-	commentCmd.Flags().String("uploads_identity", "", fmt.Sprintf(`%s: keystone identity as 'tenant:username'
-                                  %s: use the '~/.aws/credentials' file or a 'AWS_ACCESS_KEY_ID' env var`, SWIFT, S3))
-	commentCmd.Flags().String("uploads_secret", "", fmt.Sprintf(`%s: keystone password
-                                  %s: use the '~/.aws/credentials' file or a 'AWS_SECRET_ACCESS_KEY' env var`, SWIFT, S3))
-	commentCmd.Flags().StringP("uploads_bucket", "b", "", "required if 'uploads' isset: bucket to upload the files to (will be made public)")
-	commentCmd.Flags().IntP("uploads_expire", "e", 0, "optional: number of days to keep the uploaded files before they are removed")
-	commentCmd.Flags().Int("uploads_concurrency", 4, "optional: number of files to be uploaded concurrently")
-	viper.BindPFlag("pr_num", commentCmd.Flags().Lookup("pr_num"))
+	uploadsFlags(commentCmd.Flags())
 	viper.BindPFlag("file", commentCmd.Flags().Lookup("comment_file"))
 	viper.BindPFlag("title", commentCmd.Flags().Lookup("title"))
+	viper.BindPFlag("sticky", commentCmd.Flags().Lookup("sticky"))
+	viper.BindPFlag("supersede", commentCmd.Flags().Lookup("supersede"))
+	viper.BindPFlag("supersede_mode", commentCmd.Flags().Lookup("supersede_mode"))
+	viper.BindPFlag("junit", commentCmd.Flags().Lookup("junit"))
 	viper.BindPFlag("uploads", commentCmd.Flags().Lookup("uploads"))
-	viper.BindPFlag("uploads_api", commentCmd.Flags().Lookup("uploads_api"))
-	viper.BindPFlag("uploads_endpoint", commentCmd.Flags().Lookup("uploads_endpoint"))
-	viper.BindPFlag("uploads_region", commentCmd.Flags().Lookup("uploads_region"))
-	viper.BindPFlag("uploads_identity", commentCmd.Flags().Lookup("uploads_identity"))
-	viper.BindPFlag("uploads_secret", commentCmd.Flags().Lookup("uploads_secret"))
-	viper.BindPFlag("uploads_bucket", commentCmd.Flags().Lookup("uploads_bucket"))
-	viper.BindPFlag("uploads_expire", commentCmd.Flags().Lookup("uploads_expire"))
-	viper.BindPFlag("uploads_concurrency", commentCmd.Flags().Lookup("uploads_concurrency"))
+	bindUploadsFlags(commentCmd.Flags())
 }
 
 func commentCheckUsage() {
@@ -135,8 +118,8 @@ func commentCheckUsage() {
 		return buffer.Bytes()
 	}
 
-	if !viper.IsSet("token") {
-		missing = append(missing, "token")
+	if !viper.IsSet("token") && !viper.IsSet("app_id") {
+		missing = append(missing, "(token || app_id)")
 	}
 	if !viper.IsSet("owner") {
 		missing = append(missing, "owner")
@@ -153,37 +136,22 @@ func commentCheckUsage() {
 		invalid += "ERROR: You must either pass in a 'comment_file' or pip in 'stdin'\n"
 	}
 
-	if viper.IsSet("uploads") {
-		if !viper.IsSet("uploads_api") {
-			missing = append(missing, "uploads_api")
-		}
-		if !viper.IsSet("uploads_endpoint") {
-			missing = append(missing, "uploads_endpoint")
-		}
-		if !viper.IsSet("uploads_bucket") {
-			missing = append(missing, "uploads_bucket")
+	invalid += resolveCheckUsage()
+	invalid += clientCheckUsage()
+	invalid += providerCheckUsage()
+	invalid += commentKeyCheckUsage()
+	if viper.IsSet("supersede") {
+		mode := strings.ToLower(viper.GetString("supersede_mode"))
+		modes := []string{COLLAPSE, DELETE}
+		if !in(modes, mode) {
+			invalid += fmt.Sprintf("ERROR: The 'supersede_mode' flag must be one of: %s\n", strings.Join(modes, ", "))
 		}
+	}
 
-		api := strings.ToLower(viper.GetString("uploads_api"))
-		apis := []string{S3, SWIFT}
-		if !in(apis, api) {
-			invalid += fmt.Sprintf("ERROR: The 'uploads_api' flag must be one of: %s\n", strings.Join(apis, ", "))
-		}
-		if api == SWIFT && !viper.IsSet("uploads_identity") {
-			missing = append(missing, "uploads_identity")
-		}
-		if api == SWIFT && !viper.IsSet("uploads_secret") {
-			missing = append(missing, "uploads_secret")
-		}
-		if api == SWIFT && viper.IsSet("uploads_identity") {
-			if !strings.Contains(viper.GetString("uploads_identity"), ":") {
-				invalid += fmt.Sprintf("ERROR: The 'uploads_identity' flag for '%s' is formatted as 'tenant:username'\n", SWIFT)
-			}
-		}
-		if api == S3 && !viper.IsSet("uploads_region") {
-			missing = append(missing, "uploads_region")
-			invalid += fmt.Sprintf("ERROR: The 'uploads_region' flag is required when using the '%s' api for 'uploads'\n", S3)
-		}
+	if viper.IsSet("uploads") {
+		uploads_missing, uploads_invalid := uploadsCheckUsage()
+		missing = append(missing, uploads_missing...)
+		invalid += uploads_invalid
 	}
 
 	if len(missing) > 0 {
@@ -213,30 +181,15 @@ func comment(cmd *cobra.Command, args []string) {
 	commentCheckUsage()
 	prs := []int{}
 	found_pr := false
-	token := viper.GetString("token")
-	owner := viper.GetString("owner")
-	repo := viper.GetString("repo")
 	commit := viper.GetString("commit")
 	pr_num := viper.GetInt("pr_num")
 	title := viper.GetString("title")
 	comment_file := viper.GetString("file")
-	api := strings.ToLower(viper.GetString("uploads_api"))
 
 	// load the templates to be used later
-	local := false // default to false
-	if viper.IsSet("custom_template") {
-		local = viper.GetBool("custom_template")
-	}
-	templates = template.Must(template.New("").Parse(
-		assets.FSMustString(local, fmt.Sprintf("%sstatic%stemplates.tpl", string(os.PathSeparator), string(os.PathSeparator))),
-	))
+	loadTemplates()
 
-	// setup authentication via a github token and create connection
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(oauth2.NoContext, ts)
-	gh := github.NewClient(tc)
+	p := newProvider()
 
 	// populate the 'prs' with the different prs to post to
 	if viper.IsSet("pr_num") {
@@ -245,33 +198,21 @@ func comment(cmd *cobra.Command, args []string) {
 
 	// if commit is set, check which prs include this commit
 	if viper.IsSet("commit") {
-		// list all pull requests and try to match one to our commit id
-		opts := &github.PullRequestListOptions{}
-		all_prs, _, err := gh.PullRequests.List(owner, repo, opts)
+		commit_prs, err := p.ResolvePullRequests(commit)
 		if err != nil {
 			log.Printf("ERROR getting commit PRs: %s\n", err.Error())
 			os.Exit(-1)
 		}
-		if len(all_prs) > 0 {
-			for _, pr := range all_prs {
-				pr_commits, _, err := gh.PullRequests.ListCommits(owner, repo, *pr.Number, nil)
-				if err != nil {
-					log.Printf("ERROR getting Commits for PR '%d': %s\n", *pr.Number, err.Error())
-					os.Exit(-1)
-				}
-				for _, pr_commit := range pr_commits {
-					if *pr_commit.SHA == commit {
-						if !in(prs, *pr.Number) {
-							prs = append(prs, *pr.Number)
-						}
-					}
-				}
+		for _, pr_int := range commit_prs {
+			if !in(prs, pr_int) {
+				prs = append(prs, pr_int)
 			}
 		}
 	}
 
 	// have at least one PR to post to, create the comment and upload files (if needed)
-	var comment *github.IssueComment
+	var comment string
+	var comment_body *CommentBody
 	if len(prs) > 0 {
 		// get comment text
 		var comment_text []byte
@@ -291,10 +232,17 @@ func comment(cmd *cobra.Command, args []string) {
 		}
 
 		// populate the CommentBody object to be passed into the template
-		comment_body := &CommentBody{
+		comment_body = &CommentBody{
 			Summary: string(comment_text),
 		}
 
+		if viper.IsSet("sticky") {
+			comment_body.Key = viper.GetString("sticky")
+		}
+		if viper.IsSet("supersede") {
+			comment_body.Key = viper.GetString("supersede")
+		}
+
 		if viper.IsSet("title") {
 			comment_body.Title = title
 		}
@@ -303,14 +251,21 @@ func comment(cmd *cobra.Command, args []string) {
 			comment_body.CommitID = commit
 		}
 
-		if viper.IsSet("uploads") {
-			comment_body.PopulateUploads()
-
-			if api == SWIFT {
-				comment_body.UploadToSwift()
+		if viper.IsSet("junit") {
+			results, err := junitResults(viper.GetString("junit"))
+			if err != nil {
+				log.Printf("ERROR: %s\n", err.Error())
+				os.Exit(-1)
 			}
-			if api == S3 {
-				comment_body.UploadToS3()
+			comment_body.TestResults = results
+		}
+
+		if viper.IsSet("uploads") {
+			comment_body.PopulateUploads(viper.GetString("uploads"))
+			err := comment_body.Upload()
+			if err != nil {
+				log.Printf("ERROR: %s, not posting the comment.\n", err.Error())
+				os.Exit(-1)
 			}
 		}
 
@@ -320,22 +275,51 @@ func comment(cmd *cobra.Command, args []string) {
 			log.Printf("ERROR executing template: %s\n", err.Error())
 			os.Exit(-1)
 		}
-		body := buf.String()
-		_body := &body
-
-		// create the issue comment on github
-		comment = &github.IssueComment{
-			Body: _body,
-		}
+		comment = buf.String()
 	}
 
 	// loop through all the PRs to comment on and make the comment
 	for _, pr_int := range prs {
 		found_pr = true
+		// update the existing sticky comment if there is one
+		if viper.IsSet("sticky") {
+			existing, err := findComments(p, pr_int, comment_body.Marker())
+			if err != nil {
+				log.Printf("ERROR getting Comments for PR '%d': %s\n", pr_int, err.Error())
+				os.Exit(-1)
+			}
+			if len(existing) > 0 {
+				comment_id := existing[len(existing)-1].ID
+				log.Printf("Updating comment '%d' on PR '%d' with details.\n", comment_id, pr_int)
+				err = p.EditComment(pr_int, comment_id, comment)
+				if err != nil {
+					log.Printf("ERROR: %s\n", err.Error())
+					os.Exit(-1)
+				}
+				continue
+			}
+		}
+
+		// collapse or delete the comments this one supersedes
+		if viper.IsSet("supersede") {
+			existing, err := findComments(p, pr_int, comment_body.Marker())
+			if err != nil {
+				log.Printf("ERROR getting Comments for PR '%d': %s\n", pr_int, err.Error())
+				os.Exit(-1)
+			}
+			for _, c := range existing {
+				err = supersedeComment(p, pr_int, c, comment_body.Marker())
+				if err != nil {
+					log.Printf("ERROR superseding comment '%d' on PR '%d': %s\n", c.ID, pr_int, err.Error())
+					os.Exit(-1)
+				}
+			}
+		}
+
 		// Proceed commenting on all relevant PRs
 		log.Printf("Updating PR '%d' with details.\n", pr_int)
 
-		_, _, err := gh.Issues.CreateComment(owner, repo, pr_int, comment)
+		err := p.CreateComment(pr_int, comment)
 		if err != nil {
 			log.Printf("ERROR: %s\n", err.Error())
 			os.Exit(-1)
@@ -349,322 +333,69 @@ func comment(cmd *cobra.Command, args []string) {
 
 }
 
-// Populates the Uploads section of the CommentBody struct
-func (c *CommentBody) PopulateUploads() {
-	uploads := viper.GetString("uploads")
-	c.Uploads = make(map[string][]Upload)
-
-	// local code reuse for populating the 'Uploads' field
-	populate_upload := func(path string) {
-		dir := filepath.Dir(path)
-		name := filepath.Base(path)
-		obj := strings.Replace(path, "..", "up", -1) // replace '..' in the obj path
-		obj = strings.TrimPrefix(obj, string(os.PathSeparator))
-		obj = filepath.ToSlash(obj) // fix windows paths
-
-		if _, exists := c.Uploads[dir]; exists {
-			c.Uploads[dir] = append(c.Uploads[dir], Upload{
-				Name: name,
-				Path: path,
-				Obj:  obj,
-			})
-		} else {
-			c.Uploads[dir] = []Upload{
-				{
-					Name: name,
-					Path: path,
-					Obj:  obj,
-				},
-			}
-		}
+// Validate the 'sticky' and 'supersede' keys, an empty key would match every comment
+func commentKeyCheckUsage() string {
+	invalid := ""
+	if viper.IsSet("sticky") && viper.IsSet("supersede") {
+		invalid += "ERROR: The 'sticky' and 'supersede' flags can not be used together\n"
 	}
-
-	items := strings.Split(uploads, ",")
-	for _, item := range items {
-		clean := filepath.Clean(strings.TrimSpace(item))
-		f, err := os.Open(clean)
-		if err != nil {
-			log.Printf("ERROR: Failed to open upload file '%s'.\n", clean)
-			continue
-		}
-		defer f.Close()
-		fi, err := f.Stat()
-		if err != nil {
-			log.Printf("ERROR: Failed to stat upload file '%s'.\n", clean)
-			continue
-		}
-		switch mode := fi.Mode(); {
-		// Process a directory
-		case mode.IsDir():
-			err = filepath.Walk(clean, func(path string, info os.FileInfo, _ error) (err error) {
-				if info.Mode().IsRegular() {
-					sub_clean := filepath.Clean(strings.TrimSpace(path))
-					populate_upload(sub_clean)
-				}
-				return nil
-			})
-			if err != nil {
-				log.Printf("ERROR: Walking upload directory '%s'.\n", clean)
-			}
-		// Process a regular file
-		case mode.IsRegular():
-			populate_upload(clean)
+	for _, key := range []string{"sticky", "supersede"} {
+		if viper.IsSet(key) && strings.TrimSpace(viper.GetString(key)) == "" {
+			invalid += fmt.Sprintf("ERROR: The '%s' flag can not be empty\n", key)
 		}
 	}
+	return invalid
 }
 
-// Upload the files via the Swift API
-func (c *CommentBody) UploadToSwift() {
-	var tenant, username string
-	bucket := viper.GetString("uploads_bucket")
-	expires := viper.GetInt("uploads_expire")
-	var expire_time time.Time
-	if expires != 0 {
-		// Making the Swift format match the required S3 format of '2014-04-08T00:00:00.000Z', truncated to midnight on GTM time
-		expire_time = time.Now().Truncate(time.Duration(24) * time.Hour).Add(time.Duration(expires+1) * 24 * time.Hour)
-		c.UploadsExpire = &expire_time
-	}
-
-	// get the details about the identity (tenant and user)
-	parts := strings.Split(viper.GetString("uploads_identity"), ":")
-	if len(parts) > 1 {
-		tenant = parts[0]
-		username = parts[1]
-	} else {
-		log.Printf("ERROR: The 'uploads_identity' flag for '%s' is formatted as 'tenant:username'\n", SWIFT)
-		commentCmd.Help()
-		os.Exit(-1)
-	}
-
-	// make a swift connection
-	conn := swift.Connection{
-		Tenant:   tenant,
-		UserName: username,
-		ApiKey:   viper.GetString("uploads_secret"),
-		AuthUrl:  viper.GetString("uploads_endpoint"),
-	}
-
-	// authenticate swift user
-	err := conn.Authenticate()
-	if err != nil {
-		log.Println("ERROR: Swift authentication failed.  Validate your credentials are correct.")
-		os.Exit(-1)
+// The hidden marker embedded in the comment to identify it by its Key
+func (c *CommentBody) Marker() string {
+	if c.Key == "" {
+		return ""
 	}
+	return fmt.Sprintf("<!-- upr:%s -->", c.Key)
+}
 
-	// create the container if it does not already exist
-	err = conn.ContainerCreate(bucket, nil)
-	if err != nil {
-		log.Printf("ERROR: Problem creating bucket '%s'\n", bucket)
-		log.Println(err)
-		os.Exit(-1)
+// Find the comments on a pull request which include the marker, oldest first
+func findComments(p Provider, pr_num int, marker string) ([]Comment, error) {
+	if marker == "" { // every comment would match
+		return []Comment{}, nil
 	}
-
-	// update container headers
-	metadata := make(swift.Metadata, 0)
-	headers := metadata.ContainerHeaders()
-	headers["X-Container-Read"] = ".r:*,.rlistings" // make the container public
-	err = conn.ContainerUpdate(bucket, headers)
+	comments, err := p.ListComments(pr_num)
 	if err != nil {
-		log.Printf("ERROR: Problem updating headers to make bucket '%s' public\n", bucket)
-		log.Println(err)
-		os.Exit(-1)
+		return nil, err
 	}
-
-	log.Printf("Using bucket: %s\n", bucket)
-	log.Println("Starting upload...  This can take a while, go get a coffee.  :)")
-
-	// do the actual upload
-	process_upload := func(u *Upload) error {
-		if len(u.Obj) > 0 {
-			log.Printf("  started: %s\n", u.Obj)
-			f, err := os.Open(u.Path)
-			if err != nil {
-				log.Printf("ERROR: Problem opening file '%s'\n", u.Path)
-				log.Println(err)
-				return err
-			}
-			defer f.Close()
-			obj_metadata := make(swift.Metadata, 0)
-			obj_headers := obj_metadata.ObjectHeaders()
-			if expires != 0 {
-				obj_headers["X-Delete-At"] = fmt.Sprintf("%d", expire_time.Unix())
-			}
-			// currently NOT validating the hash of the upload since I expect large files
-			_, err = conn.ObjectPut(bucket, u.Obj, f, false, "", "", obj_headers)
-			if err != nil {
-				log.Printf("ERROR: Problem uploading object '%s'\n", u.Obj)
-				log.Println(err)
-				return err
-			}
-			log.Printf(" uploaded: %s\n", u.Obj)
-			u.URL = fmt.Sprintf("%s/%s/%s", strings.TrimRight(conn.StorageUrl, "/"), bucket, u.Obj)
+	found := []Comment{}
+	for _, c := range comments {
+		if strings.Contains(c.Body, marker) {
+			found = append(found, c)
 		}
-		return nil
-	}
-
-	// setup 'process_upload' concurrency controls
-	uploadc := make(chan *Upload)
-	var wg sync.WaitGroup
-	// setup the number of concurrent goroutine workers
-	for i := 0; i < viper.GetInt("uploads_concurrency"); i++ {
-		wg.Add(1)
-		go func() {
-			for u := range uploadc {
-				process_upload(u)
-			}
-			wg.Done()
-		}()
 	}
-	// feed the uploads into the concurrent goroutines to be uploaded
-	for dir, uploads := range c.Uploads { // loop through the map
-		for i, _ := range uploads { // loop through each dir list
-			uploadc <- &c.Uploads[dir][i] // point to the object so we can modify it inline
-		}
-	}
-	close(uploadc)
-	wg.Wait()
+	return found, nil
 }
 
-// Upload the files via the S3 API
-func (c *CommentBody) UploadToS3() {
-	bucket := viper.GetString("uploads_bucket")
-	endpoint := viper.GetString("uploads_endpoint")
-	region := viper.GetString("uploads_region")
-	expires := viper.GetInt("uploads_expire")
-	var expire_time time.Time
-	if expires != 0 {
-		// S3 requires the format to be '2014-04-08T00:00:00.000Z', truncated to midnight on GTM time
-		expire_time = time.Now().Truncate(time.Duration(24) * time.Hour).Add(time.Duration(expires+1) * 24 * time.Hour)
-		c.UploadsExpire = &expire_time
+// Delete a superseded comment or collapse it into an 'outdated' block based on 'supersede_mode'
+func supersedeComment(p Provider, pr_num int, c Comment, marker string) error {
+	if strings.ToLower(viper.GetString("supersede_mode")) == DELETE {
+		log.Printf("Deleting superseded comment '%d'.\n", c.ID)
+		return p.DeleteComment(pr_num, c.ID)
 	}
 
-	conn := s3.New(session.New(), &aws.Config{
-		Endpoint: aws.String(endpoint),
-		Region:   aws.String(region),
-	})
-
-	// does bucket exist?
-	head_bucket_params := &s3.HeadBucketInput{
-		Bucket: aws.String(bucket), // Required
+	// drop the marker so the collapsed comment is not superseded again
+	outdated := &OutdatedBody{
+		Body: strings.TrimSpace(strings.Replace(c.Body, marker, "", -1)),
 	}
-	_, err := conn.HeadBucket(head_bucket_params)
-	if err != nil { // bucket did not exist, create it...
-		// create a bucket
-		create_bucket_params := &s3.CreateBucketInput{
-			Bucket: aws.String(bucket), // Required
-		}
-		_, err = conn.CreateBucket(create_bucket_params)
-		if err != nil {
-			log.Printf("ERROR: Problem creating bucket '%s'\n", bucket)
-			log.Println(err)
-			os.Exit(-1)
-		}
+	if match := commit_marker.FindStringSubmatch(outdated.Body); match != nil {
+		outdated.CommitID = match[1]
+		outdated.Body = strings.TrimSpace(strings.Replace(outdated.Body, match[0], "", -1))
 	}
 
-	// update the acls for the bucket
-	acl_bucket_params := &s3.PutBucketAclInput{
-		Bucket: aws.String(bucket),
-		ACL:    aws.String(s3.BucketCannedACLPublicRead),
-	}
-	_, err = conn.PutBucketAcl(acl_bucket_params)
+	var buf bytes.Buffer
+	err := templates.ExecuteTemplate(&buf, "pr_comment_outdated", outdated)
 	if err != nil {
-		log.Printf("ERROR: Problem updating ACLs to make bucket '%s' public\n", bucket)
-		log.Println(err)
-		os.Exit(-1)
-	}
-
-	// set the expire time for the bucket
-	if expires != 0 {
-		bucket_lifecycle_params := &s3.PutBucketLifecycleConfigurationInput{
-			Bucket: aws.String(bucket), // Required
-			LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
-				Rules: []*s3.LifecycleRule{ // Required
-					{ // Required
-						Prefix: aws.String("upload-expires"),           // Required
-						Status: aws.String(s3.ExpirationStatusEnabled), // Required
-						Expiration: &s3.LifecycleExpiration{
-							Date: aws.Time(expire_time),
-						},
-					},
-				},
-			},
-		}
-		_, err = conn.PutBucketLifecycleConfiguration(bucket_lifecycle_params)
-		if err != nil {
-			log.Printf("ERROR: Problem updating lifecycle to automatically expire objects in bucket '%s'\n", bucket)
-			log.Println(err)
-			os.Exit(-1)
-		}
-	}
-
-	log.Printf("Using bucket: %s\n", bucket)
-	log.Println("Starting upload...  This can take a while, go get a coffee.  :)")
-
-	// do the actual upload
-	process_upload := func(u *Upload) error {
-		if len(u.Obj) > 0 {
-			if expires != 0 {
-				u.Obj = fmt.Sprintf("upload-expires/%s", u.Obj)
-			}
-			log.Printf("  started: %s\n", u.Obj)
-			f, err := os.Open(u.Path)
-			if err != nil {
-				log.Printf("ERROR: Problem opening file '%s'\n", u.Path)
-				log.Println(err)
-				return err
-			}
-			defer f.Close()
-			put_obj_params := &s3.PutObjectInput{
-				Bucket: aws.String(bucket),
-				Key:    aws.String(u.Obj),
-				Body:   f,
-			}
-			if expires != 0 {
-				put_obj_params.Expires = aws.Time(expire_time)
-			}
-			_, err = conn.PutObject(put_obj_params)
-			if err != nil {
-				log.Printf("ERROR: Problem uploading object '%s'\n", u.Obj)
-				log.Println(err)
-				return err
-			}
-			// update the acls for the object
-			acl_object_params := &s3.PutObjectAclInput{
-				Bucket: aws.String(bucket),
-				Key:    aws.String(u.Obj),
-				ACL:    aws.String(s3.ObjectCannedACLPublicRead),
-			}
-			_, err = conn.PutObjectAcl(acl_object_params)
-			if err != nil {
-				log.Printf("ERROR: Problem updating ACLs to make object '%s' public\n", u.Obj)
-				log.Println(err)
-				return err
-			}
-			log.Printf(" uploaded: %s\n", u.Obj)
-			u.URL = fmt.Sprintf("%s/%s/%s", strings.TrimRight(endpoint, "/"), bucket, u.Obj)
-		}
-		return nil
+		return err
 	}
+	body := buf.String()
 
-	// setup 'process_upload' concurrency controls
-	uploadc := make(chan *Upload)
-	var wg sync.WaitGroup
-	// setup the number of concurrent goroutine workers
-	for i := 0; i < viper.GetInt("uploads_concurrency"); i++ {
-		wg.Add(1)
-		go func() {
-			for u := range uploadc {
-				process_upload(u)
-			}
-			wg.Done()
-		}()
-	}
-	// feed the uploads into the concurrent goroutines to be uploaded
-	for dir, uploads := range c.Uploads { // loop through the map
-		for i, _ := range uploads { // loop through each dir list
-			uploadc <- &c.Uploads[dir][i] // point to the object so we can modify it inline
-		}
-	}
-	close(uploadc)
-	wg.Wait()
+	log.Printf("Collapsing superseded comment '%d'.\n", c.ID)
+	return p.EditComment(pr_num, c.ID, body)
 }
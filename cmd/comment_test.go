@@ -0,0 +1,91 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// An in memory provider for the comments of a single pull request
+type fakeProvider struct {
+	comments []Comment
+}
+
+func (p *fakeProvider) CreateStatus(commit, state, desc, context, url string) error { return nil }
+func (p *fakeProvider) PullRequestHead(pr_num int) (string, error)                  { return "abc123", nil }
+func (p *fakeProvider) ResolvePullRequests(commit string) ([]int, error)            { return []int{1}, nil }
+func (p *fakeProvider) ListComments(pr_num int) ([]Comment, error)                  { return p.comments, nil }
+func (p *fakeProvider) CreateComment(pr_num int, body string) error {
+	p.comments = append(p.comments, Comment{ID: len(p.comments) + 1, Body: body})
+	return nil
+}
+func (p *fakeProvider) EditComment(pr_num, id int, body string) error { return nil }
+func (p *fakeProvider) DeleteComment(pr_num, id int) error            { return nil }
+
+func TestCommentKeyCheckUsage(t *testing.T) {
+	cases := []struct {
+		sticky    interface{}
+		supersede interface{}
+		invalid   string
+	}{
+		{"build", nil, ""},
+		{nil, "build", ""},
+		{nil, nil, ""},
+		{"", nil, "'sticky' flag can not be empty"},
+		{nil, "  ", "'supersede' flag can not be empty"},
+		{"build", "build", "can not be used together"},
+	}
+	for _, c := range cases {
+		setConfig(t, "sticky", c.sticky)
+		setConfig(t, "supersede", c.supersede)
+		invalid := commentKeyCheckUsage()
+		if (c.invalid == "") != (invalid == "") || !strings.Contains(invalid, c.invalid) {
+			t.Errorf("sticky %v, supersede %v: expected '%s', got '%s'", c.sticky, c.supersede, c.invalid, invalid)
+		}
+	}
+}
+
+func TestFindComments(t *testing.T) {
+	p := &fakeProvider{comments: []Comment{
+		{ID: 1, Body: "LGTM"},
+		{ID: 2, Body: "<!-- upr:build -->\nBuild passed"},
+		{ID: 3, Body: "<!-- upr:lint -->\nLint passed"},
+		{ID: 4, Body: "<!-- upr:build -->\nBuild failed"},
+	}}
+	cases := []struct {
+		key string
+		ids []int
+	}{
+		{"build", []int{2, 4}},
+		{"lint", []int{3}},
+		{"docs", []int{}},
+		{"", []int{}}, // an empty key must not match the comments of others
+	}
+	for _, c := range cases {
+		found, err := findComments(p, 1, (&CommentBody{Key: c.key}).Marker())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, comment := range found {
+			ids = append(ids, comment.ID)
+		}
+		if !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("key '%s': expected the comments %v, got %v", c.key, c.ids, ids)
+		}
+	}
+}
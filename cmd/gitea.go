@@ -0,0 +1,205 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const GITEA_PAGE_SIZE int = 50 // the default maximum page size of Gitea
+
+// Posts statuses and pull request comments via the Gitea (or Forgejo) v1 api
+type GiteaProvider struct {
+	BaseURL string
+	Token   string
+	Owner   string
+	Repo    string
+	Client  *http.Client
+}
+
+type giteaPullRequest struct {
+	Number int    `json:"number"`
+	State  string `json:"state"`
+	Head   struct {
+		SHA string `json:"sha"`
+	} `json:"head"`
+}
+
+type giteaComment struct {
+	ID   int    `json:"id"`
+	Body string `json:"body"`
+}
+
+// Create a Gitea connection to 'owner/repo' authenticated via the 'token'
+func newGiteaProvider() *GiteaProvider {
+	return &GiteaProvider{
+		BaseURL: strings.TrimSuffix(viper.GetString("gitea_url"), "/"),
+		Token:   viper.GetString("token"),
+		Owner:   viper.GetString("owner"),
+		Repo:    viper.GetString("repo"),
+		Client:  retryClient(),
+	}
+}
+
+// Send a request to the Gitea api of the repo and decode the response into 'v'
+func (p *GiteaProvider) request(method, path string, body, v interface{}) error {
+	u := fmt.Sprintf("%s/repos/%s/%s/%s", p.BaseURL, p.Owner, p.Repo, path)
+	_, err := apiRequest(p.Client, method, u, func(req *http.Request) {
+		req.Header.Set("Authorization", "token "+p.Token)
+	}, body, v)
+	return err
+}
+
+// Gitea supports the same states as Github
+func (p *GiteaProvider) CreateStatus(commit, state, desc, context, url string) error {
+	status := map[string]string{
+		"state":       state,
+		"context":     context,
+		"description": desc,
+		"target_url":  url,
+	}
+	return p.request("POST", fmt.Sprintf("statuses/%s", commit), status, nil)
+}
+
+func (p *GiteaProvider) PullRequestHead(pr_num int) (string, error) {
+	pr := &giteaPullRequest{}
+	err := p.request("GET", fmt.Sprintf("pulls/%d", pr_num), nil, pr)
+	if err != nil {
+		return "", err
+	}
+	if pr.Head.SHA == "" {
+		return "", fmt.Errorf("PR '%d' does not have a head commit", pr_num)
+	}
+	return pr.Head.SHA, nil
+}
+
+// Resolve the pull requests including a commit using the 'pr_resolver' strategy.
+// The 'commit_pulls' strategy only finds the pull request a commit was merged through (and needs Gitea 1.17 or later),
+// so the pull requests are scanned unless only closed ones are wanted or the lookup fails.
+func (p *GiteaProvider) ResolvePullRequests(commit string) ([]int, error) {
+	pr_state := strings.ToLower(viper.GetString("pr_state"))
+	if strings.ToLower(viper.GetString("pr_resolver")) == SCAN || pr_state != "closed" {
+		return p.scanPullRequests(commit)
+	}
+	pr := &giteaPullRequest{}
+	err := p.request("GET", fmt.Sprintf("commits/%s/pull", commit), nil, pr)
+	if api_err, ok := err.(*apiError); ok && api_err.StatusCode == http.StatusNotFound {
+		return p.scanPullRequests(commit) // not merged through a pull request, or an older version without the route
+	}
+	if err != nil {
+		log.Printf("WARNING: Could not get the PR of commit '%s', falling back to a scan: %s\n", commit, err.Error())
+		return p.scanPullRequests(commit)
+	}
+	if pr.State != pr_state {
+		return p.scanPullRequests(commit)
+	}
+	return []int{pr.Number}, nil
+}
+
+// Scan the commits of every pull request for the commit (one call per pull request)
+func (p *GiteaProvider) scanPullRequests(commit string) ([]int, error) {
+	pr_state := strings.ToLower(viper.GetString("pr_state"))
+	prs := []int{}
+	for page := 1; ; page++ {
+		all_prs := []giteaPullRequest{}
+		err := p.request("GET", fmt.Sprintf("pulls?state=%s&limit=%d&page=%d", pr_state, GITEA_PAGE_SIZE, page), nil, &all_prs)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range all_prs {
+			if pr.Head.SHA == commit {
+				prs = append(prs, pr.Number)
+				continue
+			}
+			found, err := p.pullRequestIncludes(pr.Number, commit)
+			if err != nil {
+				return nil, fmt.Errorf("getting Commits for PR '%d': %s", pr.Number, err.Error())
+			}
+			if found {
+				prs = append(prs, pr.Number)
+			}
+		}
+		if len(all_prs) < GITEA_PAGE_SIZE {
+			break
+		}
+	}
+	return prs, nil
+}
+
+// Check if the commits of a pull request include the commit, versions of Gitea older than 1.17 only match the head commit
+func (p *GiteaProvider) pullRequestIncludes(pr_num int, commit string) (bool, error) {
+	for page := 1; ; page++ {
+		pr_commits := []struct {
+			SHA string `json:"sha"`
+		}{}
+		err := p.request("GET", fmt.Sprintf("pulls/%d/commits?limit=%d&page=%d", pr_num, GITEA_PAGE_SIZE, page), nil, &pr_commits)
+		if api_err, ok := err.(*apiError); ok && api_err.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, pr_commit := range pr_commits {
+			if pr_commit.SHA == commit {
+				return true, nil
+			}
+		}
+		if len(pr_commits) < GITEA_PAGE_SIZE {
+			return false, nil
+		}
+	}
+}
+
+// Page through the comments of the issue, oldest first
+func (p *GiteaProvider) ListComments(pr_num int) ([]Comment, error) {
+	comments := []Comment{}
+	first_id := 0
+	for page := 1; ; page++ {
+		issue_comments := []giteaComment{}
+		err := p.request("GET", fmt.Sprintf("issues/%d/comments?limit=%d&page=%d", pr_num, GITEA_PAGE_SIZE, page), nil, &issue_comments)
+		if err != nil {
+			return nil, err
+		}
+		// versions which do not page the comments return all of them for every page
+		if len(issue_comments) == 0 || issue_comments[0].ID == first_id {
+			break
+		}
+		first_id = issue_comments[0].ID
+		for _, c := range issue_comments {
+			comments = append(comments, Comment{ID: c.ID, Body: c.Body})
+		}
+		if len(issue_comments) < GITEA_PAGE_SIZE {
+			break
+		}
+	}
+	return comments, nil
+}
+
+func (p *GiteaProvider) CreateComment(pr_num int, body string) error {
+	return p.request("POST", fmt.Sprintf("issues/%d/comments", pr_num), map[string]string{"body": body}, nil)
+}
+
+func (p *GiteaProvider) EditComment(pr_num, id int, body string) error {
+	return p.request("PATCH", fmt.Sprintf("issues/comments/%d", id), map[string]string{"body": body}, nil)
+}
+
+func (p *GiteaProvider) DeleteComment(pr_num, id int) error {
+	return p.request("DELETE", fmt.Sprintf("issues/comments/%d", id), nil, nil)
+}
@@ -0,0 +1,185 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// A stand in for the Gitea api of 'owner/repo', which pages everything by 'limit' unless it is 'legacy'
+type fakeGitea struct {
+	legacy      bool // an old version which neither pages the comments nor has the commit pull route
+	prs         []giteaPullRequest
+	pr_commits  map[int][]string
+	commit_pull *giteaPullRequest // the PR the commit was merged through
+	comments    []giteaComment
+	requests    []gitlabRequest
+}
+
+// The items of a page, based on the 'limit' and 'page' query parameters
+func fakePage(r *http.Request, count int) (int, int) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	start, end := (page-1)*limit, page*limit
+	if start > count {
+		start = count
+	}
+	if end > count {
+		end = count
+	}
+	return start, end
+}
+
+func (f *fakeGitea) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := gitlabRequest{Method: r.Method, Path: r.URL.Path, Token: r.Header.Get("Authorization")}
+	data, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(data, &req.Body)
+	f.requests = append(f.requests, req)
+
+	var number int
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/v1/repos/owner/repo/commits/abc123/pull":
+		if f.legacy || f.commit_pull == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(f.commit_pull)
+	case r.Method == "GET" && r.URL.Path == "/api/v1/repos/owner/repo/pulls":
+		start, end := fakePage(r, len(f.prs))
+		json.NewEncoder(w).Encode(f.prs[start:end])
+	case r.Method == "GET" && sscanf(r.URL.Path, "/api/v1/repos/owner/repo/pulls/%d/commits", &number):
+		commits := []map[string]string{}
+		for _, sha := range f.pr_commits[number] {
+			commits = append(commits, map[string]string{"sha": sha})
+		}
+		start, end := fakePage(r, len(commits))
+		json.NewEncoder(w).Encode(commits[start:end])
+	case r.Method == "GET" && sscanf(r.URL.Path, "/api/v1/repos/owner/repo/issues/%d/comments", &number):
+		if f.legacy {
+			json.NewEncoder(w).Encode(f.comments)
+			return
+		}
+		start, end := fakePage(r, len(f.comments))
+		json.NewEncoder(w).Encode(f.comments[start:end])
+	default:
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	}
+}
+
+// Scan a path like fmt.Sscanf, only reporting if it matched
+func sscanf(path, format string, number *int) bool {
+	_, err := fmt.Sscanf(path, format, number)
+	return err == nil && fmt.Sprintf(format, *number) == path
+}
+
+func newTestGiteaProvider(srv *httptest.Server) *GiteaProvider {
+	return &GiteaProvider{BaseURL: srv.URL + "/api/v1", Token: "secret", Owner: "owner", Repo: "repo", Client: retryClient()}
+}
+
+func TestGiteaCreateStatus(t *testing.T) {
+	f := &fakeGitea{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	if err := newTestGiteaProvider(srv).CreateStatus("abc123", "error", "Could not run", "ci/build", "https://ci.example.com/1"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []gitlabRequest{
+		{"POST", "/api/v1/repos/owner/repo/statuses/abc123", "token secret", map[string]string{
+			"state": "error", "context": "ci/build", "description": "Could not run", "target_url": "https://ci.example.com/1"}},
+	}
+	if !reflect.DeepEqual(f.requests, expected) {
+		t.Errorf("expected the requests %v, got %v", expected, f.requests)
+	}
+}
+
+func TestGiteaListComments(t *testing.T) {
+	comments := []giteaComment{}
+	for i := 1; i <= 2*GITEA_PAGE_SIZE+5; i++ {
+		comments = append(comments, giteaComment{ID: i, Body: fmt.Sprintf("comment %d", i)})
+	}
+	for _, legacy := range []bool{false, true} {
+		f := &fakeGitea{legacy: legacy, comments: comments}
+		srv := httptest.NewServer(f)
+		found, err := newTestGiteaProvider(srv).ListComments(3)
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != len(comments) || found[0].ID != 1 || found[len(found)-1].ID != len(comments) {
+			t.Errorf("legacy %v: expected %d comments in order, got %d", legacy, len(comments), len(found))
+		}
+		// the paged version needs a request per page, the legacy version is stopped by the repeated first page
+		if len(f.requests) != 3 && !legacy || len(f.requests) != 2 && legacy {
+			t.Errorf("legacy %v: unexpected number of requests %d", legacy, len(f.requests))
+		}
+	}
+}
+
+func TestGiteaResolvePullRequests(t *testing.T) {
+	// the commit is the head of PR 7 and in the commits of PR 55, on the second page of PRs
+	prs := []giteaPullRequest{}
+	for i := 1; i <= GITEA_PAGE_SIZE+10; i++ {
+		pr := giteaPullRequest{Number: i, State: "open"}
+		pr.Head.SHA = fmt.Sprintf("head%d", i)
+		if i == 7 {
+			pr.Head.SHA = "abc123"
+		}
+		prs = append(prs, pr)
+	}
+	pr_commits := map[int][]string{55: {}}
+	for i := 0; i < GITEA_PAGE_SIZE+1; i++ {
+		pr_commits[55] = append(pr_commits[55], fmt.Sprintf("commit%d", i))
+	}
+	pr_commits[55] = append(pr_commits[55], "abc123")
+	merged := &giteaPullRequest{Number: 9, State: "closed"}
+
+	cases := []struct {
+		name        string
+		pr_state    string
+		resolver    string
+		legacy      bool
+		commit_pull *giteaPullRequest
+		prs         []int
+	}{
+		{"open", "open", COMMIT_PULLS, false, merged, []int{7, 55}},
+		{"open scan", "open", SCAN, false, merged, []int{7, 55}},
+		{"merged", "closed", COMMIT_PULLS, false, merged, []int{9}},
+		{"not merged", "closed", COMMIT_PULLS, false, nil, []int{7, 55}},
+		{"legacy", "closed", COMMIT_PULLS, true, merged, []int{7, 55}},
+	}
+	for _, c := range cases {
+		setConfig(t, "pr_state", c.pr_state)
+		setConfig(t, "pr_resolver", c.resolver)
+		f := &fakeGitea{legacy: c.legacy, prs: prs, pr_commits: pr_commits, commit_pull: c.commit_pull}
+		srv := httptest.NewServer(f)
+		found, err := newTestGiteaProvider(srv).ResolvePullRequests("abc123")
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if !reflect.DeepEqual(found, c.prs) {
+			t.Errorf("%s: expected the PRs %v, got %v", c.name, c.prs, found)
+		}
+	}
+}
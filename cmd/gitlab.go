@@ -0,0 +1,161 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Posts statuses and merge request notes via the GitLab v4 api
+type GitlabProvider struct {
+	BaseURL *url.URL
+	Token   string
+	Project string // the 'owner/repo' path, url encoded as the project id
+	Client  *http.Client
+}
+
+type gitlabMergeRequest struct {
+	IID   int    `json:"iid"`
+	State string `json:"state"`
+	SHA   string `json:"sha"`
+}
+
+type gitlabNote struct {
+	ID     int    `json:"id"`
+	Body   string `json:"body"`
+	System bool   `json:"system"`
+}
+
+// Create a GitLab connection to the project 'owner/repo' authenticated via the 'token'
+func newGitlabProvider() *GitlabProvider {
+	base_url, _ := url.Parse(strings.TrimSuffix(viper.GetString("gitlab_url"), "/") + "/") // validated in 'providerCheckUsage'
+	return &GitlabProvider{
+		BaseURL: base_url,
+		Token:   viper.GetString("token"),
+		Project: url.PathEscape(fmt.Sprintf("%s/%s", viper.GetString("owner"), viper.GetString("repo"))),
+		Client:  retryClient(),
+	}
+}
+
+// Send a request to the GitLab api and decode the response into 'v', returns the next page (0 if there is none)
+func (p *GitlabProvider) request(method, path string, body, v interface{}) (int, error) {
+	// the path is appended as is to keep the escaped project id
+	header, err := apiRequest(p.Client, method, p.BaseURL.String()+path, func(req *http.Request) {
+		req.Header.Set("PRIVATE-TOKEN", p.Token)
+	}, body, v)
+	if err != nil {
+		return 0, err
+	}
+	next_page, _ := strconv.Atoi(header.Get("X-Next-Page"))
+	return next_page, nil
+}
+
+// GitLab does not have an 'error' state, so both 'failure' and 'error' are posted as 'failed'
+func (p *GitlabProvider) CreateStatus(commit, state, desc, context, url string) error {
+	gitlab_state := state
+	switch state {
+	case "failure", "error":
+		gitlab_state = "failed"
+	}
+	status := map[string]string{
+		"state":       gitlab_state,
+		"name":        context,
+		"description": desc,
+	}
+	if url != "" {
+		status["target_url"] = url
+	}
+	_, err := p.request("POST", fmt.Sprintf("projects/%s/statuses/%s", p.Project, commit), status, nil)
+	return err
+}
+
+func (p *GitlabProvider) PullRequestHead(pr_num int) (string, error) {
+	mr := &gitlabMergeRequest{}
+	_, err := p.request("GET", fmt.Sprintf("projects/%s/merge_requests/%d", p.Project, pr_num), nil, mr)
+	if err != nil {
+		return "", err
+	}
+	if mr.SHA == "" {
+		return "", fmt.Errorf("MR '%d' does not have a head commit", pr_num)
+	}
+	return mr.SHA, nil
+}
+
+// The merge requests are filtered by 'pr_state', where 'closed' includes the merged merge requests
+func (p *GitlabProvider) ResolvePullRequests(commit string) ([]int, error) {
+	pr_state := strings.ToLower(viper.GetString("pr_state"))
+	prs := []int{}
+	page := 1
+	for page != 0 {
+		mrs := []gitlabMergeRequest{}
+		var err error
+		page, err = p.request("GET", fmt.Sprintf("projects/%s/repository/commits/%s/merge_requests?per_page=100&page=%d",
+			p.Project, commit, page), nil, &mrs)
+		if err != nil {
+			return nil, err
+		}
+		for _, mr := range mrs {
+			open := mr.State == "opened" || mr.State == "locked"
+			if pr_state == "all" || (pr_state == "open") == open {
+				prs = append(prs, mr.IID)
+			}
+		}
+	}
+	return prs, nil
+}
+
+// The system notes (like 'added 1 commit') are not included
+func (p *GitlabProvider) ListComments(pr_num int) ([]Comment, error) {
+	comments := []Comment{}
+	page := 1
+	for page != 0 {
+		notes := []gitlabNote{}
+		var err error
+		page, err = p.request("GET", fmt.Sprintf("projects/%s/merge_requests/%d/notes?sort=asc&order_by=created_at&per_page=100&page=%d",
+			p.Project, pr_num, page), nil, &notes)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range notes {
+			if !n.System {
+				comments = append(comments, Comment{ID: n.ID, Body: n.Body})
+			}
+		}
+	}
+	return comments, nil
+}
+
+func (p *GitlabProvider) CreateComment(pr_num int, body string) error {
+	_, err := p.request("POST", fmt.Sprintf("projects/%s/merge_requests/%d/notes", p.Project, pr_num),
+		map[string]string{"body": body}, nil)
+	return err
+}
+
+func (p *GitlabProvider) EditComment(pr_num, id int, body string) error {
+	_, err := p.request("PUT", fmt.Sprintf("projects/%s/merge_requests/%d/notes/%d", p.Project, pr_num, id),
+		map[string]string{"body": body}, nil)
+	return err
+}
+
+func (p *GitlabProvider) DeleteComment(pr_num, id int) error {
+	_, err := p.request("DELETE", fmt.Sprintf("projects/%s/merge_requests/%d/notes/%d", p.Project, pr_num, id), nil, nil)
+	return err
+}
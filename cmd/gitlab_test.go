@@ -0,0 +1,135 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// A request received by the fake GitLab api
+type gitlabRequest struct {
+	Method string
+	Path   string // escaped, so the project id can be checked
+	Token  string
+	Body   map[string]string
+}
+
+// A stand in for the GitLab api, the merge requests of a commit are returned a page at a time
+func fakeGitlab(requests *[]gitlabRequest, pages [][]gitlabMergeRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := gitlabRequest{Method: r.Method, Path: r.URL.EscapedPath(), Token: r.Header.Get("PRIVATE-TOKEN")}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &req.Body)
+		*requests = append(*requests, req)
+
+		switch req.Path {
+		case "/api/v4/projects/owner%2Frepo/repository/commits/abc123/merge_requests":
+			page := 1
+			fmt.Sscanf(r.URL.Query().Get("page"), "%d", &page)
+			if page < len(pages) {
+				w.Header().Set("X-Next-Page", fmt.Sprintf("%d", page+1))
+			}
+			json.NewEncoder(w).Encode(pages[page-1])
+		default:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+		}
+	}))
+}
+
+func newTestGitlabProvider(srv *httptest.Server) *GitlabProvider {
+	base_url, _ := url.Parse(srv.URL + "/api/v4/")
+	return &GitlabProvider{
+		BaseURL: base_url,
+		Token:   "secret",
+		Project: url.PathEscape("owner/repo"),
+		Client:  retryClient(),
+	}
+}
+
+func TestGitlabCreateStatus(t *testing.T) {
+	requests := []gitlabRequest{}
+	srv := fakeGitlab(&requests, nil)
+	defer srv.Close()
+	p := newTestGitlabProvider(srv)
+
+	if err := p.CreateStatus("abc123", "error", "Could not run", "ci/build", "https://ci.example.com/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CreateStatus("abc123", "pending", "Running", "ci/build", ""); err != nil {
+		t.Fatal(err)
+	}
+	expected := []gitlabRequest{
+		{"POST", "/api/v4/projects/owner%2Frepo/statuses/abc123", "secret", map[string]string{
+			"state": "failed", "name": "ci/build", "description": "Could not run", "target_url": "https://ci.example.com/1"}},
+		{"POST", "/api/v4/projects/owner%2Frepo/statuses/abc123", "secret", map[string]string{
+			"state": "pending", "name": "ci/build", "description": "Running"}},
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected the requests %v, got %v", expected, requests)
+	}
+}
+
+func TestGitlabResolvePullRequests(t *testing.T) {
+	pages := [][]gitlabMergeRequest{
+		{{IID: 1, State: "opened"}, {IID: 2, State: "merged"}},
+		{{IID: 3, State: "locked"}, {IID: 4, State: "closed"}},
+		{{IID: 5, State: "opened"}},
+	}
+	cases := map[string][]int{
+		"open":   {1, 3, 5},
+		"closed": {2, 4},
+		"all":    {1, 2, 3, 4, 5},
+	}
+	for pr_state, prs := range cases {
+		requests := []gitlabRequest{}
+		srv := fakeGitlab(&requests, pages)
+		setConfig(t, "pr_state", pr_state)
+		found, err := newTestGitlabProvider(srv).ResolvePullRequests("abc123")
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(found, prs) {
+			t.Errorf("%s: expected the MRs %v, got %v", pr_state, prs, found)
+		}
+		if len(requests) != len(pages) {
+			t.Errorf("%s: expected %d pages to be requested, got %d", pr_state, len(pages), len(requests))
+		}
+	}
+}
+
+func TestGitlabCreateComment(t *testing.T) {
+	requests := []gitlabRequest{}
+	srv := fakeGitlab(&requests, nil)
+	defer srv.Close()
+
+	if err := newTestGitlabProvider(srv).CreateComment(7, "All tests passed"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []gitlabRequest{
+		{"POST", "/api/v4/projects/owner%2Frepo/merge_requests/7/notes", "secret", map[string]string{"body": "All tests passed"}},
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected the requests %v, got %v", expected, requests)
+	}
+}
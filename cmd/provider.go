@@ -0,0 +1,235 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/viper"
+)
+
+const (
+	GITHUB    string = "github"
+	GITLAB    string = "gitlab"
+	GITEA     string = "gitea"
+	BITBUCKET string = "bitbucket"
+)
+
+// A comment on a pull request (or merge request)
+type Comment struct {
+	ID   int
+	Body string
+}
+
+// The service hosting the repo, which the statuses and comments are posted to
+type Provider interface {
+	// Post a status ('pending', 'success', 'failure' or 'error') on a commit
+	CreateStatus(commit, state, desc, context, url string) error
+	// The head commit of a pull request
+	PullRequestHead(pr_num int) (string, error)
+	// The numbers of the pull requests which include a commit
+	ResolvePullRequests(commit string) ([]int, error)
+	// The comments on a pull request, oldest first
+	ListComments(pr_num int) ([]Comment, error)
+	CreateComment(pr_num int, body string) error
+	EditComment(pr_num, id int, body string) error
+	DeleteComment(pr_num, id int) error
+}
+
+// An error response from the api of a provider
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+type GithubProvider struct {
+	Client *github.Client
+	Owner  string
+	Repo   string
+}
+
+// Validate the 'provider' flag, returns the errors to display
+func providerCheckUsage() string {
+	invalid := ""
+	provider := strings.ToLower(viper.GetString("provider"))
+	providers := []string{GITHUB, GITLAB, GITEA, BITBUCKET}
+	found := false
+	for _, p := range providers {
+		if p == provider {
+			found = true
+		}
+	}
+	if !found {
+		invalid += fmt.Sprintf("ERROR: The 'provider' flag must be one of: %s\n", strings.Join(providers, ", "))
+	}
+	if provider == GITLAB || provider == GITEA {
+		key := fmt.Sprintf("%s_url", provider)
+		if viper.GetString(key) == "" {
+			invalid += fmt.Sprintf("ERROR: The '%s' flag is required when using the '%s' provider\n", key, provider)
+		} else if _, err := url.Parse(viper.GetString(key)); err != nil {
+			invalid += fmt.Sprintf("ERROR: The '%s' flag is not a valid url: %s\n", key, err.Error())
+		}
+	}
+	if provider == BITBUCKET {
+		invalid += bitbucketCheckUsage()
+	}
+	if provider != GITHUB && !viper.IsSet("token") {
+		invalid += fmt.Sprintf("ERROR: The 'token' flag is required when using the '%s' provider\n", provider)
+	}
+	return invalid
+}
+
+// Create a connection to the 'provider' hosting the repo
+func newProvider() Provider {
+	switch strings.ToLower(viper.GetString("provider")) {
+	case GITLAB:
+		return newGitlabProvider()
+	case GITEA:
+		return newGiteaProvider()
+	case BITBUCKET:
+		return newBitbucketProvider()
+	default:
+		return newGithubProvider(githubClient())
+	}
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// Send a json request to the api of a provider and decode the response into 'v', returns the response headers for paging
+func apiRequest(client *http.Client, method, u string, auth func(*http.Request), body, v interface{}) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	auth(req)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, &apiError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("%s %s: %s %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg))),
+		}
+	}
+	if v != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return nil, err
+		}
+	}
+	return resp.Header, nil
+}
+
+func newGithubProvider(gh *github.Client) *GithubProvider {
+	return &GithubProvider{
+		Client: gh,
+		Owner:  viper.GetString("owner"),
+		Repo:   viper.GetString("repo"),
+	}
+}
+
+func (p *GithubProvider) CreateStatus(commit, state, desc, context, url string) error {
+	// RepoStatus takes type *string
+	_state := &state
+	_context := &context
+	_desc := &desc
+	_url := &url
+	repo_status := &github.RepoStatus{
+		State:       _state,
+		Description: _desc,
+		Context:     _context,
+		TargetURL:   _url,
+	}
+	_, _, err := p.Client.Repositories.CreateStatus(p.Owner, p.Repo, commit, repo_status)
+	return err
+}
+
+func (p *GithubProvider) PullRequestHead(pr_num int) (string, error) {
+	pr, _, err := p.Client.PullRequests.Get(p.Owner, p.Repo, pr_num)
+	if err != nil {
+		return "", err
+	}
+	if pr.Head == nil || pr.Head.SHA == nil {
+		return "", fmt.Errorf("PR '%d' does not have a head commit", pr_num)
+	}
+	return *pr.Head.SHA, nil
+}
+
+func (p *GithubProvider) ResolvePullRequests(commit string) ([]int, error) {
+	return githubPullRequests(p.Client, commit)
+}
+
+func (p *GithubProvider) ListComments(pr_num int) ([]Comment, error) {
+	found := []Comment{}
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		comments, resp, err := p.Client.Issues.ListComments(p.Owner, p.Repo, pr_num, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range comments {
+			if c.ID != nil && c.Body != nil {
+				found = append(found, Comment{ID: *c.ID, Body: *c.Body})
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.ListOptions.Page = resp.NextPage
+	}
+	return found, nil
+}
+
+func (p *GithubProvider) CreateComment(pr_num int, body string) error {
+	_, _, err := p.Client.Issues.CreateComment(p.Owner, p.Repo, pr_num, &github.IssueComment{Body: &body})
+	return err
+}
+
+func (p *GithubProvider) EditComment(pr_num, id int, body string) error {
+	_, _, err := p.Client.Issues.EditComment(p.Owner, p.Repo, id, &github.IssueComment{Body: &body})
+	return err
+}
+
+func (p *GithubProvider) DeleteComment(pr_num, id int) error {
+	_, err := p.Client.Issues.DeleteComment(p.Owner, p.Repo, id)
+	return err
+}
@@ -0,0 +1,118 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const WAIT string = "wait"
+
+// The error returned once the quota is exhausted when 'rate_limit' is 'fail', which is not retried
+type rateLimitError struct {
+	Message string
+}
+
+func (e *rateLimitError) Error() string {
+	return e.Message
+}
+
+// Tracks the Github api quota from the 'X-RateLimit-*' headers of the responses.
+// Once the quota is exhausted, the requests either wait for it to reset or fail based on 'rate_limit'.
+type rateLimitTransport struct {
+	Base      http.RoundTripper
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for {
+		if err := t.waitForQuota(); err != nil {
+			return nil, err
+		}
+		resp, err := t.Base.RoundTrip(req)
+		if err != nil || !t.update(resp) {
+			return resp, err
+		}
+
+		// the request was rejected since the quota is exhausted, send it again after the reset if possible
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+		if viper.GetString("rate_limit") != WAIT {
+			resp.Body.Close()
+			return nil, t.exhausted()
+		}
+		resp.Body.Close()
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.WithContext(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// Wait until the quota resets if it is known to be exhausted, or fail if 'rate_limit' is 'fail'
+func (t *rateLimitTransport) waitForQuota() error {
+	t.mu.Lock()
+	remaining, reset := t.remaining, t.reset
+	t.mu.Unlock()
+	if remaining > 0 || !reset.After(time.Now()) {
+		return nil
+	}
+	if viper.GetString("rate_limit") != WAIT {
+		return t.exhausted()
+	}
+	wait := reset.Sub(time.Now()) + time.Second // a little slack for the clock skew
+	log.Printf("WARNING: The Github api rate limit is exhausted, waiting %s for it to reset.\n", wait-wait%time.Second)
+	time.Sleep(wait)
+	return nil
+}
+
+// Record the quota from the response headers, returns true if the request was rejected by the rate limit
+func (t *rateLimitTransport) update(resp *http.Response) bool {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return false
+	}
+	limit, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+
+	t.mu.Lock()
+	t.limit, t.remaining, t.reset = limit, remaining, time.Unix(reset, 0)
+	log.Printf("Github api quota: %d of %d requests remaining, resets at %s.\n", remaining, limit, t.reset.Format(time.RFC3339))
+	t.mu.Unlock()
+
+	return remaining == 0 && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests)
+}
+
+func (t *rateLimitTransport) exhausted() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &rateLimitError{fmt.Sprintf("the Github api rate limit of %d requests is exhausted until %s, use '--rate_limit %s' to wait for it",
+		t.limit, t.reset.Format(time.RFC3339), WAIT)}
+}
@@ -0,0 +1,132 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake Github api which rejects the requests once the quota of 'limit' requests is used until 'reset'
+type fakeGithub struct {
+	mu       sync.Mutex
+	limit    int
+	used     int
+	reset    time.Time
+	requests int
+}
+
+func (f *fakeGithub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if !time.Now().Before(f.reset) {
+		f.used = 0
+		f.reset = time.Now().Add(time.Hour)
+	}
+	remaining := f.limit - f.used
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(f.limit))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(f.reset.Unix(), 10))
+	if remaining == 0 {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "API rate limit exceeded"}`))
+		return
+	}
+	f.used++
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining-1))
+	w.Write([]byte(`{}`))
+}
+
+// A client retrying over the rate limit transport, like the Github client
+func rateLimitClient() *http.Client {
+	return &http.Client{Transport: &retryTransport{Base: &rateLimitTransport{Base: http.DefaultTransport}}}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	tr := &rateLimitTransport{}
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	resp.Header.Set("X-RateLimit-Limit", "5000")
+	resp.Header.Set("X-RateLimit-Remaining", "4999")
+	resp.Header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if tr.update(resp) {
+		t.Error("a successful response was reported as rate limited")
+	}
+	if tr.limit != 5000 || tr.remaining != 4999 || !tr.reset.Equal(reset) {
+		t.Errorf("parsed limit=%d remaining=%d reset=%s", tr.limit, tr.remaining, tr.reset)
+	}
+
+	resp.StatusCode = http.StatusForbidden
+	resp.Header.Set("X-RateLimit-Remaining", "0")
+	if !tr.update(resp) {
+		t.Error("an exhausted quota was not reported as rate limited")
+	}
+
+	// responses without the headers, like the ones of other apis, are ignored
+	tr = &rateLimitTransport{}
+	if tr.update(&http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}) || tr.limit != 0 {
+		t.Error("a response without rate limit headers was used")
+	}
+}
+
+func TestRateLimitFail(t *testing.T) {
+	setConfig(t, "rate_limit", FAIL)
+	setConfig(t, "retries", 3)
+	gh := &fakeGithub{limit: 1}
+	srv := httptest.NewServer(gh)
+	defer srv.Close()
+	client := rateLimitClient()
+
+	if _, err := apiRequest(client, "GET", srv.URL+"/repos/o/r", func(*http.Request) {}, nil, nil); err != nil {
+		t.Fatalf("the first request failed: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := apiRequest(client, "GET", srv.URL+"/repos/o/r", func(*http.Request) {}, nil, nil)
+		if err == nil || !strings.Contains(err.Error(), "rate limit of 1 requests is exhausted") {
+			t.Fatalf("expected a rate limit error, got: %v", err)
+		}
+	}
+	// the quota is known to be exhausted from the first response, so the others are neither sent nor retried
+	if gh.requests != 1 {
+		t.Errorf("expected 1 request to the api, got %d", gh.requests)
+	}
+}
+
+func TestRateLimitWait(t *testing.T) {
+	setConfig(t, "rate_limit", WAIT)
+	setConfig(t, "retries", 0)
+	gh := &fakeGithub{limit: 1, used: 1, reset: time.Now().Add(time.Second)}
+	srv := httptest.NewServer(gh)
+	defer srv.Close()
+	client := rateLimitClient()
+
+	start := time.Now()
+	body := map[string]string{"body": "comment"}
+	if _, err := apiRequest(client, "POST", srv.URL+"/repos/o/r/issues/1/comments", func(*http.Request) {}, body, nil); err != nil {
+		t.Fatalf("the request failed instead of waiting: %s", err)
+	}
+	if time.Since(start) < time.Second {
+		t.Errorf("the request did not wait for the reset, it took %s", time.Since(start))
+	}
+	if gh.requests != 2 {
+		t.Errorf("expected the rejected request to be sent again once, got %d requests", gh.requests)
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	tap_result    = regexp.MustCompile(`^(not ok|ok)\b\s*(\d+)?\s*(?:-\s*)?([^#]*?)\s*(?:#\s*(\w+).*)?$`)
+	tap_bail_out  = regexp.MustCompile(`^Bail out!\s*(.*)$`)
+	tap_yaml_open = regexp.MustCompile(`^\s+---\s*$`)
+	tap_yaml_end  = regexp.MustCompile(`^\s+\.\.\.\s*$`)
+)
+
+type TestFailure struct {
+	Suite   string
+	Name    string
+	Message string
+	Details string
+}
+
+type TestResults struct {
+	Total    int
+	Passed   int
+	Failed   int
+	Skipped  int
+	Duration time.Duration
+	Failures []TestFailure
+	Errors   []string // the errors which stopped the run, like a TAP 'Bail out!'
+}
+
+type test2jsonEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string         `xml:"name,attr"`
+	ClassName string         `xml:"classname,attr"`
+	Time      string         `xml:"time,attr"`
+	Failures  []junitProblem `xml:"failure"`
+	Errors    []junitProblem `xml:"error"`
+	Skipped   *junitProblem  `xml:"skipped"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Add the results of another report to these results
+func (r *TestResults) Add(o *TestResults) {
+	r.Total += o.Total
+	r.Passed += o.Passed
+	r.Failed += o.Failed
+	r.Skipped += o.Skipped
+	r.Duration += o.Duration
+	r.Failures = append(r.Failures, o.Failures...)
+	r.Errors = append(r.Errors, o.Errors...)
+}
+
+// Summarize the results like '412 passed, 3 failed, 7 skipped', after the errors which stopped the run
+func (r *TestResults) String() string {
+	summary := "No tests were found"
+	if r.Total > 0 {
+		summary = fmt.Sprintf("%d passed, %d failed, %d skipped", r.Passed, r.Failed, r.Skipped)
+	}
+	if len(r.Errors) > 0 {
+		return fmt.Sprintf("%s (%s)", strings.Join(r.Errors, ", "), summary)
+	}
+	return summary
+}
+
+// The status state matching the results
+func (r *TestResults) State() string {
+	if r.Total == 0 || len(r.Errors) > 0 {
+		return "error"
+	}
+	if r.Failed > 0 {
+		return "failure"
+	}
+	return "success"
+}
+
+// Parse the JUnit XML files matching a comma separated list of files or globs
+func junitResults(patterns string) (*TestResults, error) {
+	return testResults(patterns, "JUnit", parseJUnit)
+}
+
+// Combine the results of the 'from_junit', 'from_tap' and 'from_test2json' reports
+func reportResults(junit, tap, test2json string) (*TestResults, error) {
+	results := &TestResults{}
+	reports := []struct {
+		patterns string
+		kind     string
+		parse    func([]byte) (*TestResults, error)
+	}{
+		{junit, "JUnit", parseJUnit},
+		{tap, "TAP", parseTAP},
+		{test2json, "test2json", parseTest2json},
+	}
+	for _, report := range reports {
+		if report.patterns == "" {
+			continue
+		}
+		report_results, err := testResults(report.patterns, report.kind, report.parse)
+		if err != nil {
+			return nil, err
+		}
+		if report_results != nil {
+			results.Add(report_results)
+		}
+	}
+	return results, nil
+}
+
+// Parse the report files matching a comma separated list of files or globs, nil if no files match
+func testResults(patterns, kind string, parse func([]byte) (*TestResults, error)) (*TestResults, error) {
+	results := &TestResults{}
+	found := false
+	for _, pattern := range strings.Split(patterns, ",") {
+		matches, err := filepath.Glob(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern '%s': %s", kind, pattern, err.Error())
+		}
+		if len(matches) == 0 {
+			log.Printf("WARNING: No %s files match '%s'.\n", kind, strings.TrimSpace(pattern))
+		}
+		for _, path := range matches {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s file '%s': %s", kind, path, err.Error())
+			}
+			file_results, err := parse(data)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s file '%s': %s", kind, path, err.Error())
+			}
+			results.Add(file_results)
+			found = true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return results, nil
+}
+
+// Parse a JUnit XML report, the root can either be 'testsuites' or a single 'testsuite'
+func parseJUnit(data []byte) (*TestResults, error) {
+	root := junitSuite{}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	results := &TestResults{}
+	addJUnitSuite(results, root)
+	results.Duration = results.Duration - results.Duration%time.Millisecond
+	return results, nil
+}
+
+// Count the test cases of the suite and all of its nested suites
+func addJUnitSuite(results *TestResults, suite junitSuite) {
+	for _, tc := range suite.Cases {
+		results.Total++
+		var secs float64
+		fmt.Sscanf(tc.Time, "%f", &secs)
+		results.Duration += time.Duration(secs * float64(time.Second))
+
+		problems := append(tc.Failures, tc.Errors...)
+		switch {
+		case len(problems) > 0:
+			results.Failed++
+			name := tc.Name
+			if tc.ClassName != "" {
+				name = fmt.Sprintf("%s.%s", tc.ClassName, tc.Name)
+			}
+			results.Failures = append(results.Failures, TestFailure{
+				Suite:   suite.Name,
+				Name:    name,
+				Message: problems[0].Message,
+				Details: strings.TrimSpace(problems[0].Text),
+			})
+		case tc.Skipped != nil:
+			results.Skipped++
+		default:
+			results.Passed++
+		}
+	}
+	for _, s := range suite.Suites {
+		addJUnitSuite(results, s)
+	}
+}
+
+// Parse a TAP (Test Anything Protocol) report, only the top level test points are counted.
+// A 'Bail out!' stops the parsing and is reported as an error of the run.
+func parseTAP(data []byte) (*TestResults, error) {
+	results := &TestResults{}
+	in_yaml := false
+	var yaml []string
+	// the diagnostics of a failing test point follow it in a yaml block
+	end_yaml := func() {
+		if len(results.Failures) > 0 && len(yaml) > 0 {
+			results.Failures[len(results.Failures)-1].Details = strings.Join(yaml, "\n")
+		}
+		in_yaml = false
+		yaml = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	last_failed := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if in_yaml {
+			if tap_yaml_end.MatchString(line) {
+				end_yaml()
+			} else if last_failed {
+				yaml = append(yaml, strings.TrimSpace(line))
+			}
+			continue
+		}
+		if tap_yaml_open.MatchString(line) {
+			in_yaml = true
+			continue
+		}
+		if match := tap_bail_out.FindStringSubmatch(line); match != nil {
+			results.Errors = append(results.Errors, strings.TrimSpace("Bail out! "+match[1]))
+			break
+		}
+		match := tap_result.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		results.Total++
+		last_failed = false
+		directive := strings.ToUpper(match[4])
+		switch {
+		case directive == "SKIP" || directive == "TODO":
+			results.Skipped++
+		case match[1] == "ok":
+			results.Passed++
+		default:
+			results.Failed++
+			last_failed = true
+			name := match[3]
+			if name == "" {
+				name = fmt.Sprintf("test %s", match[2])
+			}
+			results.Failures = append(results.Failures, TestFailure{
+				Name: name,
+			})
+		}
+	}
+	if in_yaml {
+		end_yaml()
+	}
+	return results, scanner.Err()
+}
+
+// Parse the output of 'go test -json' (or 'go tool test2json'), only the leaf tests are counted so the
+// subtests are not counted twice, a parent test only counts if it failed without any of its subtests failing
+func parseTest2json(data []byte) (*TestResults, error) {
+	results := &TestResults{}
+	output := map[string][]string{}
+	details := map[string]string{}
+	finished := []*test2jsonEvent{} // the parents finish after their subtests, so the results are counted at the end
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // output lines can be long
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		event := &test2jsonEvent{}
+		if err := json.Unmarshal(line, event); err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("%s.%s", event.Package, event.Test)
+		switch event.Action {
+		case "output":
+			if event.Test != "" {
+				output[key] = append(output[key], event.Output)
+			}
+		case "pass", "fail", "skip":
+			if event.Test == "" { // the package level result
+				results.Duration += time.Duration(event.Elapsed * float64(time.Second))
+				continue
+			}
+			finished = append(finished, event)
+			if event.Action == "fail" {
+				details[key] = strings.TrimSpace(strings.Join(output[key], ""))
+			}
+			delete(output, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// find the tests with subtests, and the ones with failed subtests
+	parents := map[string]bool{}
+	failed_parents := map[string]bool{}
+	for _, event := range finished {
+		for name := event.Test; strings.Contains(name, "/"); {
+			name = name[:strings.LastIndex(name, "/")]
+			key := fmt.Sprintf("%s.%s", event.Package, name)
+			parents[key] = true
+			if event.Action == "fail" {
+				failed_parents[key] = true
+			}
+		}
+	}
+
+	for _, event := range finished {
+		key := fmt.Sprintf("%s.%s", event.Package, event.Test)
+		if parents[key] && (event.Action != "fail" || failed_parents[key]) {
+			continue
+		}
+		results.Total++
+		switch event.Action {
+		case "pass":
+			results.Passed++
+		case "skip":
+			results.Skipped++
+		case "fail":
+			results.Failed++
+			results.Failures = append(results.Failures, TestFailure{
+				Suite:   event.Package,
+				Name:    event.Test,
+				Details: details[key],
+			})
+		}
+	}
+	results.Duration = results.Duration - results.Duration%time.Millisecond
+	return results, nil
+}
@@ -0,0 +1,206 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseJUnit(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="api">
+    <testcase classname="api.Client" name="testGet" time="0.5"/>
+    <testcase classname="api.Client" name="testPost" time="0.25">
+      <failure message="expected 201" type="AssertionError">expected 201 but was 500
+  at Client.post</failure>
+    </testcase>
+    <testsuite name="api.nested">
+      <testcase name="testTimeout" time="1.0005">
+        <error message="connection refused" type="IOError"/>
+      </testcase>
+      <testcase name="testSlow">
+        <skipped message="too slow"/>
+      </testcase>
+    </testsuite>
+  </testsuite>
+  <testsuite name="ui">
+    <testcase name="renders" time="0.1"/>
+  </testsuite>
+</testsuites>`
+	results, err := parseJUnit([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &TestResults{
+		Total:    5,
+		Passed:   2,
+		Failed:   2,
+		Skipped:  1,
+		Duration: 1850 * time.Millisecond,
+		Failures: []TestFailure{
+			{Suite: "api", Name: "api.Client.testPost", Message: "expected 201", Details: "expected 201 but was 500\n  at Client.post"},
+			{Suite: "api.nested", Name: "testTimeout", Message: "connection refused"},
+		},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %+v, got %+v", expected, results)
+	}
+	if results.State() != "failure" || results.String() != "2 passed, 2 failed, 1 skipped" {
+		t.Errorf("unexpected state '%s' or summary '%s'", results.State(), results.String())
+	}
+
+	// a single 'testsuite' as the root
+	results, err = parseJUnit([]byte(`<testsuite name="solo"><testcase name="a"/><testcase name="b"/></testsuite>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Total != 2 || results.Passed != 2 || results.State() != "success" {
+		t.Errorf("unexpected results for a single suite: %+v", results)
+	}
+
+	if _, err := parseJUnit([]byte(`<testsuites><testsuite>`)); err == nil {
+		t.Errorf("expected an error for invalid xml")
+	}
+}
+
+func TestResultsWithoutTests(t *testing.T) {
+	results, err := parseJUnit([]byte(`<testsuites/>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.State() != "error" || results.String() != "No tests were found" {
+		t.Errorf("unexpected state '%s' or summary '%s'", results.State(), results.String())
+	}
+
+	// the results of several reports are combined
+	results.Add(&TestResults{Total: 3, Passed: 2, Skipped: 1, Duration: time.Second})
+	if results.State() != "success" || results.String() != "2 passed, 0 failed, 1 skipped" {
+		t.Errorf("unexpected state '%s' or summary '%s'", results.State(), results.String())
+	}
+}
+
+func TestParseTAP(t *testing.T) {
+	data := `TAP version 13
+1..6
+ok 1 - connects
+not ok 2 - writes the file
+  ---
+  message: 'permission denied'
+  severity: fail
+  ...
+ok 3 - reads the cache # SKIP no cache configured
+not ok 4 - retries # TODO not implemented yet
+not ok 5
+# a comment
+    ok 1 - a subtest is not counted
+ok 6 reconnects
+`
+	results, err := parseTAP([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &TestResults{
+		Total:   6,
+		Passed:  2,
+		Failed:  2,
+		Skipped: 2,
+		Failures: []TestFailure{
+			{Name: "writes the file", Details: "message: 'permission denied'\nseverity: fail"},
+			{Name: "test 5"},
+		},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %+v, got %+v", expected, results)
+	}
+	if results.State() != "failure" {
+		t.Errorf("expected the state 'failure', got '%s'", results.State())
+	}
+}
+
+func TestParseTAPBailOut(t *testing.T) {
+	cases := []struct {
+		data    string
+		total   int
+		summary string
+	}{
+		{"1..3\nok 1 - connects\nBail out! Database unavailable\nok 2 - ignored\n", 1,
+			"Bail out! Database unavailable (1 passed, 0 failed, 0 skipped)"},
+		{"Bail out!\n", 0, "Bail out! (No tests were found)"},
+	}
+	for _, c := range cases {
+		results, err := parseTAP([]byte(c.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results.Total != c.total || results.Failed != 0 || len(results.Failures) != 0 {
+			t.Errorf("expected the bail out to stop the run without a failed test, got %+v", results)
+		}
+		if results.State() != "error" || results.String() != c.summary {
+			t.Errorf("expected the state 'error' and the summary '%s', got '%s' and '%s'", c.summary, results.State(), results.String())
+		}
+	}
+}
+
+func TestParseTest2json(t *testing.T) {
+	data := `{"Action":"run","Package":"example.com/pkg","Test":"TestParent"}
+{"Action":"run","Package":"example.com/pkg","Test":"TestParent/ok"}
+{"Action":"output","Package":"example.com/pkg","Test":"TestParent/ok","Output":"=== RUN   TestParent/ok\n"}
+{"Action":"pass","Package":"example.com/pkg","Test":"TestParent/ok","Elapsed":0}
+{"Action":"run","Package":"example.com/pkg","Test":"TestParent/broken"}
+{"Action":"output","Package":"example.com/pkg","Test":"TestParent/broken","Output":"    pkg_test.go:12: boom\n"}
+{"Action":"fail","Package":"example.com/pkg","Test":"TestParent/broken","Elapsed":0}
+{"Action":"output","Package":"example.com/pkg","Test":"TestParent","Output":"--- FAIL: TestParent (0.00s)\n"}
+{"Action":"fail","Package":"example.com/pkg","Test":"TestParent","Elapsed":0}
+{"Action":"run","Package":"example.com/pkg","Test":"TestSelf"}
+{"Action":"run","Package":"example.com/pkg","Test":"TestSelf/nested/deep"}
+{"Action":"pass","Package":"example.com/pkg","Test":"TestSelf/nested/deep","Elapsed":0}
+{"Action":"pass","Package":"example.com/pkg","Test":"TestSelf/nested","Elapsed":0}
+{"Action":"output","Package":"example.com/pkg","Test":"TestSelf","Output":"    pkg_test.go:30: parent only\n"}
+{"Action":"fail","Package":"example.com/pkg","Test":"TestSelf","Elapsed":0}
+{"Action":"run","Package":"example.com/pkg","Test":"TestPassing"}
+{"Action":"run","Package":"example.com/pkg","Test":"TestPassing/a"}
+{"Action":"pass","Package":"example.com/pkg","Test":"TestPassing/a","Elapsed":0}
+{"Action":"pass","Package":"example.com/pkg","Test":"TestPassing","Elapsed":0}
+{"Action":"skip","Package":"example.com/pkg","Test":"TestSkipped","Elapsed":0}
+{"Action":"fail","Package":"example.com/pkg","Elapsed":1.5}
+`
+	results, err := parseTest2json([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the leaves are TestParent/ok, TestParent/broken, TestSelf/nested/deep, TestPassing/a and TestSkipped,
+	// TestSelf also counts since it failed without any of its subtests failing
+	expected := &TestResults{
+		Total:    6,
+		Passed:   3,
+		Failed:   2,
+		Skipped:  1,
+		Duration: 1500 * time.Millisecond,
+		Failures: []TestFailure{
+			{Suite: "example.com/pkg", Name: "TestParent/broken", Details: "pkg_test.go:12: boom"},
+			{Suite: "example.com/pkg", Name: "TestSelf", Details: "pkg_test.go:30: parent only"},
+		},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %+v, got %+v", expected, results)
+	}
+
+	if _, err := parseTest2json([]byte("{\"Action\": \n")); err == nil {
+		t.Errorf("expected an error for invalid json")
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/viper"
+)
+
+const (
+	COMMIT_PULLS string = "commit_pulls"
+	SCAN         string = "scan"
+)
+
+// Validate the flags used to resolve pull requests, returns the errors to display
+func resolveCheckUsage() string {
+	// check if a string is in a list
+	in := func(list []string, a string) bool {
+		for _, b := range list {
+			if b == a {
+				return true
+			}
+		}
+		return false
+	}
+	invalid := ""
+
+	if viper.IsSet("pr_resolver") {
+		resolver := strings.ToLower(viper.GetString("pr_resolver"))
+		resolvers := []string{COMMIT_PULLS, SCAN}
+		if !in(resolvers, resolver) {
+			invalid += fmt.Sprintf("ERROR: The 'pr_resolver' flag must be one of: %s\n", strings.Join(resolvers, ", "))
+		}
+	}
+	if viper.IsSet("pr_state") {
+		pr_state := strings.ToLower(viper.GetString("pr_state"))
+		pr_states := []string{"open", "closed", "all"}
+		if !in(pr_states, pr_state) {
+			invalid += fmt.Sprintf("ERROR: The 'pr_state' flag must be one of: %s\n", strings.Join(pr_states, ", "))
+		}
+	}
+	return invalid
+}
+
+// Resolve the commits to work with from 'commit' and the head commit of 'pr_num'
+func ResolveCommits(p Provider) ([]string, error) {
+	commits := []string{}
+	if viper.IsSet("commit") {
+		commits = append(commits, viper.GetString("commit"))
+	}
+	if viper.IsSet("pr_num") {
+		pr_num := viper.GetInt("pr_num")
+		head, err := p.PullRequestHead(pr_num)
+		if err != nil {
+			return nil, fmt.Errorf("getting PR '%d': %s", pr_num, err.Error())
+		}
+		if len(commits) == 0 || commits[0] != head {
+			commits = append(commits, head)
+		}
+	}
+	return commits, nil
+}
+
+// Resolve the numbers of the Github pull requests which include a commit, the providers use 'Provider.ResolvePullRequests'
+func ResolvePullRequests(gh *github.Client, commit string) ([]int, error) {
+	return githubPullRequests(gh, commit)
+}
+
+// Resolve the numbers of the Github pull requests which include a commit using the 'pr_resolver' strategy,
+// the commit pulls are scanned if they fail or do not find any pull request
+func githubPullRequests(gh *github.Client, commit string) ([]int, error) {
+	if strings.ToLower(viper.GetString("pr_resolver")) == SCAN {
+		return scanPullRequests(gh, commit)
+	}
+	prs, err := commitPullRequests(gh, commit)
+	if err != nil {
+		log.Printf("WARNING: Could not get the PRs of commit '%s', falling back to a scan: %s\n", commit, err.Error())
+		return scanPullRequests(gh, commit)
+	}
+	if len(prs) == 0 {
+		// the commits which are only on the head of a fork (and some unmerged heads) do not list their PRs
+		log.Printf("No PRs list commit '%s', falling back to a scan.\n", commit)
+		return scanPullRequests(gh, commit)
+	}
+	return prs, nil
+}
+
+// Ask Github directly which pull requests include the commit (a single call for most commits)
+func commitPullRequests(gh *github.Client, commit string) ([]int, error) {
+	owner := viper.GetString("owner")
+	repo := viper.GetString("repo")
+	pr_state := strings.ToLower(viper.GetString("pr_state"))
+	prs := []int{}
+
+	page := 1
+	for page != 0 {
+		u := fmt.Sprintf("repos/%s/%s/commits/%s/pulls?per_page=100&page=%d", owner, repo, commit, page)
+		req, err := gh.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		// the commit pulls endpoint is only available with the 'groot' preview
+		req.Header.Set("Accept", "application/vnd.github.groot-preview+json")
+
+		commit_prs := []github.PullRequest{}
+		resp, err := gh.Do(req, &commit_prs)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range commit_prs {
+			if pr_state == "all" || pr.State == nil || *pr.State == pr_state {
+				prs = append(prs, *pr.Number)
+			}
+		}
+		page = resp.NextPage
+	}
+	return prs, nil
+}
+
+// Scan the commits of every pull request for the commit (one call per pull request)
+func scanPullRequests(gh *github.Client, commit string) ([]int, error) {
+	// check if an int is in a list
+	in := func(list []int, a int) bool {
+		for _, b := range list {
+			if b == a {
+				return true
+			}
+		}
+		return false
+	}
+	owner := viper.GetString("owner")
+	repo := viper.GetString("repo")
+	prs := []int{}
+
+	// list all pull requests (walking every page) and try to match one to our commit id
+	opts := &github.PullRequestListOptions{
+		State:       strings.ToLower(viper.GetString("pr_state")),
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		all_prs, resp, err := gh.PullRequests.List(owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range all_prs {
+			commit_opts := &github.ListOptions{PerPage: 100}
+			for {
+				pr_commits, commit_resp, err := gh.PullRequests.ListCommits(owner, repo, *pr.Number, commit_opts)
+				if err != nil {
+					return nil, fmt.Errorf("getting Commits for PR '%d': %s", *pr.Number, err.Error())
+				}
+				for _, pr_commit := range pr_commits {
+					if *pr_commit.SHA == commit {
+						if !in(prs, *pr.Number) {
+							prs = append(prs, *pr.Number)
+						}
+					}
+				}
+				if commit_resp.NextPage == 0 {
+					break
+				}
+				commit_opts.Page = commit_resp.NextPage
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.ListOptions.Page = resp.NextPage
+	}
+	return prs, nil
+}
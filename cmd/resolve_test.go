@@ -0,0 +1,100 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+// A fake Github api where the commit is only in the second of three open PRs, the PRs are listed two per page
+func fakeGithubPulls(commit_pulls int, commit_prs string, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.URL.Path)
+		switch r.URL.Path {
+		case "/repos/owner/repo/commits/abc123/pulls":
+			w.WriteHeader(commit_pulls)
+			w.Write([]byte(commit_prs))
+		case "/repos/owner/repo/pulls":
+			if r.URL.Query().Get("page") == "2" {
+				w.Write([]byte(`[{"number": 3, "state": "open"}]`))
+				return
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/repos/owner/repo/pulls?page=2>; rel="next"`, r.Host))
+			w.Write([]byte(`[{"number": 1, "state": "open"}, {"number": 2, "state": "open"}]`))
+		default:
+			var number int
+			if _, err := fmt.Sscanf(r.URL.Path, "/repos/owner/repo/pulls/%d/commits", &number); err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			sha := "def456"
+			if number == 2 {
+				sha = "abc123"
+			}
+			json.NewEncoder(w).Encode([]map[string]string{{"sha": sha}})
+		}
+	}))
+}
+
+func TestGithubPullRequests(t *testing.T) {
+	setConfig(t, "owner", "owner")
+	setConfig(t, "repo", "repo")
+	setConfig(t, "pr_state", "open")
+	setConfig(t, "retries", 0)
+	cases := []struct {
+		name         string
+		resolver     string
+		commit_pulls int
+		commit_prs   string
+		prs          []int
+		scanned      bool
+	}{
+		{"commit pulls", COMMIT_PULLS, http.StatusOK, `[{"number": 5, "state": "open"}, {"number": 6, "state": "closed"}]`, []int{5}, false},
+		{"fork head", COMMIT_PULLS, http.StatusOK, `[]`, []int{2}, true},
+		{"commit pulls error", COMMIT_PULLS, http.StatusUnprocessableEntity, `{"message": "No commit found"}`, []int{2}, true},
+		{"scan", SCAN, http.StatusOK, `[{"number": 5, "state": "open"}]`, []int{2}, true},
+	}
+	for _, c := range cases {
+		setConfig(t, "pr_resolver", c.resolver)
+		requests := []string{}
+		srv := fakeGithubPulls(c.commit_pulls, c.commit_prs, &requests)
+		gh := github.NewClient(srv.Client())
+		gh.BaseURL, _ = url.Parse(srv.URL + "/")
+
+		prs, err := ResolvePullRequests(gh, "abc123")
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if !reflect.DeepEqual(prs, c.prs) {
+			t.Errorf("%s: expected the PRs %v, got %v", c.name, c.prs, prs)
+		}
+		scanned := false
+		for _, path := range requests {
+			scanned = scanned || path == "/repos/owner/repo/pulls"
+		}
+		if scanned != c.scanned {
+			t.Errorf("%s: expected a scan %v, got the requests %v", c.name, c.scanned, requests)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ncw/swift"
+	"github.com/spf13/viper"
+)
+
+// Github asks to wait at least a minute after hitting a secondary rate limit without a 'Retry-After'
+const SECONDARY_RATE_LIMIT_WAIT time.Duration = time.Minute
+
+// Retries the requests which failed with a network error or a transient status, with an exponential backoff.
+// Requests with a body are only retried if the body can be read again, and the requests which are not idempotent
+// only if the server refused them because of a rate limit or asked to retry them later.
+type retryTransport struct {
+	Base http.RoundTripper
+}
+
+// An http client retrying the requests via the default transport
+func retryClient() *http.Client {
+	return &http.Client{Transport: &retryTransport{Base: http.DefaultTransport}}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.Base.RoundTrip(req)
+		if attempt >= viper.GetInt("retries") || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		retry, wait := retryable(resp, err)
+		if !retry || !(idempotent(req.Method) || refused(resp, wait)) {
+			return resp, err
+		}
+		wait, ok := retryWait(attempt, wait)
+		if !ok {
+			return resp, err
+		}
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		log.Printf("WARNING: %s %s failed (%s), retrying in %s.\n", req.Method, req.URL.Path, reason, wait)
+		time.Sleep(wait)
+
+		// the body was consumed by the previous attempt
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.WithContext(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// An error which was already retried, so it is not retried again by an enclosing retry
+type retriedError struct {
+	err error
+}
+
+func (e *retriedError) Error() string {
+	return e.err.Error()
+}
+
+// Run a function until it succeeds, fails with an error which is not transient or the retries are used up,
+// for the requests which can not be retried by the transport
+func retry(what string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !transient(err) {
+			return err
+		}
+		if attempt >= viper.GetInt("retries") {
+			return &retriedError{err: err}
+		}
+		wait, _ := retryWait(attempt, 0)
+		log.Printf("WARNING: %s failed (%s), retrying in %s.\n", what, err.Error(), wait)
+		time.Sleep(wait)
+	}
+}
+
+// Whether a method can be sent again without side effects if the first request was processed
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// Whether the server refused to process a request, so it can be sent again whatever its method
+func refused(resp *http.Response, wait time.Duration) bool {
+	if resp == nil {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusForbidden: // the forbidden responses are only retried for the rate limits
+		return true
+	case http.StatusServiceUnavailable:
+		return resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// Whether an error may go away if retried, the errors with the files, the client errors and the errors
+// which were already retried are not
+func transient(err error) bool {
+	switch e := err.(type) {
+	case *retriedError, *rateLimitError, *os.PathError:
+		return false
+	case *apiError:
+		return transientStatus(e.StatusCode)
+	case *azureError:
+		return transientStatus(e.StatusCode)
+	case *swift.Error:
+		return transientStatus(e.StatusCode)
+	}
+	return true
+}
+
+func transientStatus(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// Whether a response should be retried, and how long the server asked to wait before retrying
+func retryable(resp *http.Response, err error) (bool, time.Duration) {
+	if _, ok := err.(*rateLimitError); ok {
+		return false, 0
+	}
+	if err != nil {
+		return true, 0
+	}
+	wait := retryAfter(resp.Header.Get("Retry-After"))
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, wait
+	case http.StatusForbidden:
+		// the secondary rate limits of Github are reported as forbidden, keep the body readable for the caller
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(msg))
+		if wait == 0 && strings.Contains(strings.ToLower(string(msg)), "secondary rate limit") {
+			wait = SECONDARY_RATE_LIMIT_WAIT
+		}
+		return wait != 0, wait
+	}
+	return false, 0
+}
+
+// Parse a 'Retry-After' header as either a number of seconds or a date
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(time.Now()) {
+		return date.Sub(time.Now())
+	}
+	return 0
+}
+
+// The time to wait before a retry, an exponential backoff with jitter unless the server asked for a wait.
+// Returns false if the server asked to wait longer than 'retry_max_wait'.
+func retryWait(attempt int, wait time.Duration) (time.Duration, bool) {
+	max_wait := viper.GetDuration("retry_max_wait")
+	if wait > max_wait {
+		return wait, false
+	}
+	if wait == 0 {
+		backoff := time.Second << uint(attempt)
+		if backoff <= 0 || backoff > max_wait {
+			backoff = max_wait
+		}
+		wait = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	}
+	return wait, true
+}
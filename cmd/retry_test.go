@@ -0,0 +1,193 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// A server answering every request with a status and counting the requests
+func statusServer(status int, header http.Header, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(status)
+	}))
+}
+
+// Set a config value for the duration of a test, so the tests do not depend on each other
+func setConfig(t *testing.T, key string, value interface{}) {
+	var previous interface{} // a nil value unsets the key, so it is not left set after the test
+	if viper.IsSet(key) {
+		previous = viper.Get(key)
+	}
+	viper.Set(key, value)
+	t.Cleanup(func() { viper.Set(key, previous) })
+}
+
+func setRetryConfig(t *testing.T) {
+	setConfig(t, "retries", 2)
+	setConfig(t, "retry_max_wait", 10*time.Millisecond)
+}
+
+func TestRetryTransportMethods(t *testing.T) {
+	setRetryConfig(t)
+	cases := []struct {
+		method   string
+		status   int
+		header   http.Header
+		requests int
+	}{
+		{"GET", http.StatusInternalServerError, nil, 3},
+		{"PUT", http.StatusBadGateway, nil, 3},
+		{"POST", http.StatusInternalServerError, nil, 1},
+		{"POST", http.StatusServiceUnavailable, nil, 1},
+		{"POST", http.StatusServiceUnavailable, http.Header{"Retry-After": {"0"}}, 3},
+		{"POST", http.StatusTooManyRequests, nil, 3},
+		{"PATCH", http.StatusForbidden, nil, 1},
+		{"GET", http.StatusNotFound, nil, 1},
+	}
+	for _, c := range cases {
+		requests := 0
+		srv := statusServer(c.status, c.header, &requests)
+		req, _ := http.NewRequest(c.method, srv.URL, strings.NewReader("{}"))
+		resp, err := retryClient().Do(req)
+		if err != nil {
+			t.Fatalf("%s %d: %s", c.method, c.status, err)
+		}
+		resp.Body.Close()
+		srv.Close()
+		if requests != c.requests {
+			t.Errorf("%s %d: expected %d requests, got %d", c.method, c.status, c.requests, requests)
+		}
+	}
+}
+
+func TestRetrySecondaryRateLimit(t *testing.T) {
+	default_wait, err := time.ParseDuration(RootCmd.PersistentFlags().Lookup("retry_max_wait").DefValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, "retry_max_wait", default_wait)
+	cases := []struct {
+		header http.Header
+		body   string
+		retry  bool
+		wait   time.Duration
+	}{
+		{nil, `{"message": "You have exceeded a secondary rate limit."}`, true, SECONDARY_RATE_LIMIT_WAIT},
+		{http.Header{"Retry-After": {"60"}}, `{"message": "You have exceeded a secondary rate limit."}`, true, time.Minute},
+		{nil, `{"message": "Bad credentials"}`, false, 0},
+	}
+	for _, c := range cases {
+		resp := &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     c.header,
+			Body:       ioutil.NopCloser(strings.NewReader(c.body)),
+		}
+		if resp.Header == nil {
+			resp.Header = http.Header{}
+		}
+		retry, wait := retryable(resp, nil)
+		if retry != c.retry || wait != c.wait {
+			t.Errorf("%s: expected a retry %v after %s, got %v after %s", c.body, c.retry, c.wait, retry, wait)
+		}
+		if body, _ := ioutil.ReadAll(resp.Body); string(body) != c.body {
+			t.Errorf("expected the body to stay readable, got '%s'", body)
+		}
+		if retry {
+			if _, ok := retryWait(0, wait); !ok {
+				t.Errorf("%s: expected a wait of %s to be retried with the default 'retry_max_wait'", c.body, wait)
+			}
+		}
+	}
+}
+
+func TestRetryTransportSecondaryRateLimit(t *testing.T) {
+	setRetryConfig(t)
+	setConfig(t, "retry_max_wait", 2*time.Second)
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message": "You have exceeded a secondary rate limit."}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("POST", srv.URL, strings.NewReader("{}"))
+	resp, err := retryClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if requests != 2 || resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected the POST to be retried once, got %d requests and %s", requests, resp.Status)
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	setRetryConfig(t)
+	cases := []struct {
+		err      error
+		attempts int
+	}{
+		{errors.New("connection reset by peer"), 3},
+		{&apiError{StatusCode: http.StatusServiceUnavailable}, 3},
+		{&apiError{StatusCode: http.StatusForbidden}, 1},
+		{&azureError{StatusCode: http.StatusUnauthorized}, 1},
+		{&os.PathError{Op: "open", Path: "missing.log", Err: os.ErrNotExist}, 1},
+	}
+	for _, c := range cases {
+		attempts := 0
+		err := retry("Testing", func() error {
+			attempts++
+			return c.err
+		})
+		if err == nil || err.Error() != c.err.Error() {
+			t.Errorf("%s: expected the error to be returned, got %v", c.err, err)
+		}
+		if attempts != c.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", c.err, c.attempts, attempts)
+		}
+	}
+
+	// the retries are not compounded
+	attempts := 0
+	retry("Testing", func() error {
+		return retry("Testing", func() error {
+			attempts++
+			return errors.New("connection reset by peer")
+		})
+	})
+	if attempts != 3 {
+		t.Errorf("expected the nested retries to make 3 attempts, got %d", attempts)
+	}
+}
@@ -17,9 +17,12 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"text/template"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/swill/upr/assets"
 )
 
 // This represents the base command when called without any subcommands
@@ -29,7 +32,7 @@ var RootCmd = &cobra.Command{
 	Long: `A command line tool to manipulate pull requests on Github.
 	
 This tool is designed to be integrated into a CI implementation
-in order to update the Status or add a Comment.`,
+in order to update the Status, add a Comment or report a Check.`,
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
@@ -46,16 +49,57 @@ func init() {
 
 	RootCmd.PersistentFlags().String("config", "", "config file (default is ./config.yaml)")
 	RootCmd.PersistentFlags().StringP("commit", "c", "", "commit you are working with")
-	RootCmd.PersistentFlags().String("token", "", "required: Github access token (https://github.com/settings/tokens)")
+	RootCmd.PersistentFlags().IntP("pr_num", "n", 0, "pull request number you are working with")
+	RootCmd.PersistentFlags().String("pr_resolver", COMMIT_PULLS, fmt.Sprintf(
+		"how the pull requests including 'commit' are found (%s | %s)", COMMIT_PULLS, SCAN))
+	RootCmd.PersistentFlags().String("pr_state", "open", "state of the pull requests to search for 'commit' (open | closed | all)")
+	RootCmd.PersistentFlags().String("provider", GITHUB, fmt.Sprintf(
+		"service hosting the repo (%s | %s | %s | %s)", GITHUB, GITLAB, GITEA, BITBUCKET))
+	RootCmd.PersistentFlags().String("token", "", "required unless 'app_id' isset: access token of the provider (https://github.com/settings/tokens)")
+	RootCmd.PersistentFlags().Int("app_id", 0, "id of the Github App to authenticate as instead of using a 'token'")
+	RootCmd.PersistentFlags().Int("installation_id", 0, "required if 'app_id' isset: id of the installation of the Github App")
+	RootCmd.PersistentFlags().String("app_key_file", "", "required if 'app_id' isset: private key file of the Github App")
 	RootCmd.PersistentFlags().String("owner", "", "required: owner of the repo you are working with")
 	RootCmd.PersistentFlags().String("repo", "", "required: name of the repo you are working with")
 	RootCmd.PersistentFlags().Bool("custom_template", false, "override the built in templates using a file at 'static/templates.tpl'")
+	RootCmd.PersistentFlags().String("github_url", "", "Github Enterprise url (eg: https://github.example.com)")
+	RootCmd.PersistentFlags().String("github_upload_url", "", "Github Enterprise upload url (default is derived from 'github_url')")
+	RootCmd.PersistentFlags().String("github_ca_file", "", "CA bundle to verify the Github Enterprise certificate")
+	RootCmd.PersistentFlags().String("github_cert_file", "", "client certificate to authenticate to Github Enterprise")
+	RootCmd.PersistentFlags().String("github_key_file", "", "client certificate key to authenticate to Github Enterprise")
+	RootCmd.PersistentFlags().String("gitlab_url", "https://gitlab.com/api/v4", "GitLab api url, used by the 'gitlab' provider")
+	RootCmd.PersistentFlags().String("bitbucket_url", BITBUCKET_CLOUD_URL, "Bitbucket api url, or the url of the instance if 'bitbucket_server' isset")
+	RootCmd.PersistentFlags().Bool("bitbucket_server", false, "use the Bitbucket Server (or Data Center) api instead of the Bitbucket Cloud api")
+	RootCmd.PersistentFlags().String("gitea_url", "", "Gitea api url (eg: https://gitea.example.com/api/v1), required by the 'gitea' provider")
+	RootCmd.PersistentFlags().Int("retries", 3, "number of times failed requests and uploads are retried")
+	RootCmd.PersistentFlags().Duration("retry_max_wait", 2*time.Minute, "longest wait before a retry, longer 'Retry-After' waits are not retried")
+	RootCmd.PersistentFlags().String("rate_limit", WAIT, fmt.Sprintf(
+		"what to do when the Github api rate limit is exhausted, wait for it to reset or fail (%s | %s)", WAIT, FAIL))
 	viper.BindPFlag("config", RootCmd.PersistentFlags().Lookup("config"))
 	viper.BindPFlag("commit", RootCmd.PersistentFlags().Lookup("commit"))
+	viper.BindPFlag("pr_num", RootCmd.PersistentFlags().Lookup("pr_num"))
+	viper.BindPFlag("pr_resolver", RootCmd.PersistentFlags().Lookup("pr_resolver"))
+	viper.BindPFlag("pr_state", RootCmd.PersistentFlags().Lookup("pr_state"))
+	viper.BindPFlag("provider", RootCmd.PersistentFlags().Lookup("provider"))
 	viper.BindPFlag("token", RootCmd.PersistentFlags().Lookup("token"))
+	viper.BindPFlag("app_id", RootCmd.PersistentFlags().Lookup("app_id"))
+	viper.BindPFlag("installation_id", RootCmd.PersistentFlags().Lookup("installation_id"))
+	viper.BindPFlag("app_key_file", RootCmd.PersistentFlags().Lookup("app_key_file"))
 	viper.BindPFlag("owner", RootCmd.PersistentFlags().Lookup("owner"))
 	viper.BindPFlag("repo", RootCmd.PersistentFlags().Lookup("repo"))
 	viper.BindPFlag("custom_template", RootCmd.PersistentFlags().Lookup("custom_template"))
+	viper.BindPFlag("github_url", RootCmd.PersistentFlags().Lookup("github_url"))
+	viper.BindPFlag("github_upload_url", RootCmd.PersistentFlags().Lookup("github_upload_url"))
+	viper.BindPFlag("github_ca_file", RootCmd.PersistentFlags().Lookup("github_ca_file"))
+	viper.BindPFlag("github_cert_file", RootCmd.PersistentFlags().Lookup("github_cert_file"))
+	viper.BindPFlag("github_key_file", RootCmd.PersistentFlags().Lookup("github_key_file"))
+	viper.BindPFlag("gitlab_url", RootCmd.PersistentFlags().Lookup("gitlab_url"))
+	viper.BindPFlag("gitea_url", RootCmd.PersistentFlags().Lookup("gitea_url"))
+	viper.BindPFlag("bitbucket_url", RootCmd.PersistentFlags().Lookup("bitbucket_url"))
+	viper.BindPFlag("bitbucket_server", RootCmd.PersistentFlags().Lookup("bitbucket_server"))
+	viper.BindPFlag("rate_limit", RootCmd.PersistentFlags().Lookup("rate_limit"))
+	viper.BindPFlag("retries", RootCmd.PersistentFlags().Lookup("retries"))
+	viper.BindPFlag("retry_max_wait", RootCmd.PersistentFlags().Lookup("retry_max_wait"))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -74,3 +118,14 @@ func initConfig() {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
 }
+
+// loadTemplates parses the built in templates, or the local 'static/templates.tpl' if 'custom_template' isset.
+func loadTemplates() {
+	local := false // default to false
+	if viper.IsSet("custom_template") {
+		local = viper.GetBool("custom_template")
+	}
+	templates = template.Must(template.New("").Parse(
+		assets.FSMustString(local, fmt.Sprintf("%sstatic%stemplates.tpl", string(os.PathSeparator), string(os.PathSeparator))),
+	))
+}
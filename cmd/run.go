@@ -0,0 +1,249 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run [flags] -- command [args...]",
+	Short: "Run a command and report its result as a pull request status on Github.",
+	Long: `Run a command and report its result as a pull request status on Github.
+
+This command wraps an arbitrary CI job by posting a 'pending'
+status, running the command while teeing its output to a log
+file and posting a 'success' or 'failure' status based on the
+exit code of the command, which upr then exits with.
+
+Optionally, the log file can be uploaded to an object store
+via the 'uploads_api' and linked from the status.`,
+}
+
+func init() {
+	RootCmd.AddCommand(runCmd)
+
+	runCmd.Run = run
+	runCmd.PreRun = runBindFlags
+	runCmd.Flags().SetInterspersed(false) // the flags after the command belong to the command
+	runCmd.Flags().StringP("context", "x", "", "required: the contextual identifier for this status")
+	runCmd.Flags().StringP("desc", "d", "", "optional: a short description of the environment context")
+	runCmd.Flags().StringP("url", "u", "", "optional: a reference url for more information about this status")
+	runCmd.Flags().StringP("log_file", "l", "upr-run.log", "optional: file to write the output of the command to")
+	runCmd.Flags().Bool("upload_log", false, "optional: upload the 'log_file' under '<commit>/<context>/<time>/' and use its url as the status url")
+	uploadsFlags(runCmd.Flags())
+}
+
+// The 'run' flags share names with other commands, so only bind them when 'run' runs
+func runBindFlags(cmd *cobra.Command, args []string) {
+	viper.BindPFlag("context", runCmd.Flags().Lookup("context"))
+	viper.BindPFlag("desc", runCmd.Flags().Lookup("desc"))
+	viper.BindPFlag("url", runCmd.Flags().Lookup("url"))
+	viper.BindPFlag("log_file", runCmd.Flags().Lookup("log_file"))
+	viper.BindPFlag("upload_log", runCmd.Flags().Lookup("upload_log"))
+	bindUploadsFlags(runCmd.Flags())
+}
+
+func runCheckUsage(args []string) {
+	missing := []string{}
+	usage := ""
+	invalid := ""
+
+	if !viper.IsSet("token") && !viper.IsSet("app_id") {
+		missing = append(missing, "(token || app_id)")
+	}
+	if !viper.IsSet("owner") {
+		missing = append(missing, "owner")
+	}
+	if !viper.IsSet("repo") {
+		missing = append(missing, "repo")
+	}
+	if !viper.IsSet("commit") && !viper.IsSet("pr_num") {
+		missing = append(missing, "(commit || pr_num)")
+	}
+	if !viper.IsSet("context") {
+		missing = append(missing, "context")
+	}
+	if len(args) == 0 {
+		invalid += "ERROR: You must pass in the command to run after '--'\n"
+	}
+	if viper.GetBool("upload_log") {
+		uploads_missing, uploads_invalid := uploadsCheckUsage()
+		missing = append(missing, uploads_missing...)
+		invalid += uploads_invalid
+	}
+	invalid += resolveCheckUsage()
+	invalid += clientCheckUsage()
+	invalid += providerCheckUsage()
+
+	if len(missing) > 0 {
+		usage += fmt.Sprintf("MISSING REQUIRED FLAGS: %s\n", strings.Join(missing, ", "))
+	}
+
+	usage += invalid
+	if usage != "" {
+		fmt.Printf("\n%s\n", usage)
+		runCmd.Help()
+		os.Exit(-1)
+	}
+}
+
+// Run a command bracketed by a pending and a final status
+func run(cmd *cobra.Command, args []string) {
+	runCheckUsage(args)
+	context := viper.GetString("context")
+	desc := viper.GetString("desc")
+	url := viper.GetString("url")
+	log_file := viper.GetString("log_file")
+
+	p := newProvider()
+
+	pending_desc := desc
+	if pending_desc == "" {
+		pending_desc = fmt.Sprintf("Running '%s'", strings.Join(args, " "))
+	}
+	err := postStatus(p, "pending", truncateDesc(pending_desc), context, url)
+	if err != nil {
+		log.Printf("ERROR: %s\n", err.Error())
+		os.Exit(-1)
+	}
+	log.Println("Successfully updated the status to 'pending'!")
+
+	// run the command and determine its final state
+	start := time.Now()
+	exit_code, err := runCommand(args, log_file)
+	elapsed := time.Since(start)
+	elapsed -= elapsed % time.Second
+	state := "success"
+	result := fmt.Sprintf("Succeeded in %s", elapsed)
+	if err != nil {
+		log.Printf("ERROR: %s\n", err.Error())
+		state = "error"
+		result = fmt.Sprintf("Could not run the command: %s", err.Error())
+	} else if exit_code != 0 {
+		state = "failure"
+		result = fmt.Sprintf("Failed with exit code %d after %s", exit_code, elapsed)
+	}
+	log.Println(result)
+
+	// upload the log file and link it from the status
+	var upload_err error
+	if viper.GetBool("upload_log") {
+		log_body := &CommentBody{}
+		log_body.PopulateUploads(log_file)
+		for dir := range log_body.Uploads {
+			for i := range log_body.Uploads[dir] {
+				log_body.Uploads[dir][i].Obj = runLogObj(context, start, log_file)
+			}
+		}
+		upload_err = log_body.Upload()
+		if upload_err != nil {
+			log.Printf("ERROR: %s\n", upload_err.Error())
+		}
+		for _, uploads := range log_body.Uploads {
+			for _, u := range uploads {
+				if u.URL != "" && !viper.IsSet("url") {
+					url = u.URL
+				}
+			}
+		}
+	}
+
+	if desc != "" {
+		result = fmt.Sprintf("%s: %s", desc, result)
+	}
+	err = postStatus(p, state, truncateDesc(result), context, url)
+	if err != nil {
+		log.Printf("ERROR: %s\n", err.Error())
+		os.Exit(-1)
+	}
+	log.Printf("Successfully updated the status to '%s'!\n", state)
+
+	if state == "error" || (upload_err != nil && exit_code == 0) {
+		os.Exit(-1)
+	}
+	os.Exit(exit_code)
+}
+
+// A unique object name for the log file, so the logs of other commits, contexts and runs are not overwritten
+func runLogObj(context string, start time.Time, log_file string) string {
+	ref := viper.GetString("commit")
+	if ref == "" {
+		ref = fmt.Sprintf("pr-%d", viper.GetInt("pr_num"))
+	}
+	return path.Join(ref, context, start.UTC().Format("20060102T150405Z"), filepath.Base(log_file))
+}
+
+// Run the command while teeing its output to the log file, returns the exit code of the command
+func runCommand(args []string, log_file string) (int, error) {
+	f, err := os.Create(log_file)
+	if err != nil {
+		return -1, fmt.Errorf("creating log_file '%s': %s", log_file, err.Error())
+	}
+	defer f.Close()
+
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin = os.Stdin
+	c.Stdout = io.MultiWriter(os.Stdout, f)
+	c.Stderr = io.MultiWriter(os.Stderr, f)
+	if err := c.Start(); err != nil {
+		return -1, err
+	}
+
+	// pass signals on to the command so it can clean up before we report its result
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			c.Process.Signal(sig)
+		}
+	}()
+
+	err = c.Wait()
+	if exit_err, ok := err.(*exec.ExitError); ok {
+		if status, ok := exit_err.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus(), nil
+		}
+		return 1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// Github limits the description of a status to 140 characters
+func truncateDesc(desc string) string {
+	runes := []rune(desc)
+	if len(runes) > 140 {
+		return string(runes[:137]) + "..."
+	}
+	return desc
+}
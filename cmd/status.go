@@ -20,9 +20,6 @@ import (
 	"os"
 	"strings"
 
-	"golang.org/x/oauth2"
-
-	"github.com/google/go-github/github"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -35,21 +32,30 @@ var statusCmd = &cobra.Command{
 
 This command allows an arbitrary CI implementation to
 post back the status of its run to the pull request
-related to the commit the CI was run against.`,
+related to the commit the CI was run against.
+
+The status is posted on 'commit' and/or the head
+commit of the pull request 'pr_num'.`,
 }
 
 func init() {
 	RootCmd.AddCommand(statusCmd)
 
 	statusCmd.Run = status
-	statusCmd.Flags().StringP("state", "s", "", "required: pull request state (pending | success | failure | error)")
+	statusCmd.Flags().StringP("state", "s", "", "required unless a test report isset: pull request state (pending | success | failure | error)")
 	statusCmd.Flags().StringP("desc", "d", "", "optional: a short description of the environment context")
 	statusCmd.Flags().StringP("context", "x", "", "required: the contextual identifier for this status")
 	statusCmd.Flags().StringP("url", "u", "", "optional: a reference url for more information about this status")
+	statusCmd.Flags().String("from_junit", "", "optional: comma separated list of JUnit XML files or globs to derive the 'state' and 'desc' from")
+	statusCmd.Flags().String("from_tap", "", "optional: comma separated list of TAP files or globs to derive the 'state' and 'desc' from")
+	statusCmd.Flags().String("from_test2json", "", "optional: comma separated list of 'go test -json' files or globs to derive the 'state' and 'desc' from")
 	viper.BindPFlag("state", statusCmd.Flags().Lookup("state"))
 	viper.BindPFlag("desc", statusCmd.Flags().Lookup("desc"))
 	viper.BindPFlag("context", statusCmd.Flags().Lookup("context"))
 	viper.BindPFlag("url", statusCmd.Flags().Lookup("url"))
+	viper.BindPFlag("from_junit", statusCmd.Flags().Lookup("from_junit"))
+	viper.BindPFlag("from_tap", statusCmd.Flags().Lookup("from_tap"))
+	viper.BindPFlag("from_test2json", statusCmd.Flags().Lookup("from_test2json"))
 }
 
 func statusCheckUsage() {
@@ -66,8 +72,8 @@ func statusCheckUsage() {
 	usage := ""
 	invalid := ""
 
-	if !viper.IsSet("token") {
-		missing = append(missing, "token")
+	if !viper.IsSet("token") && !viper.IsSet("app_id") {
+		missing = append(missing, "(token || app_id)")
 	}
 	if !viper.IsSet("owner") {
 		missing = append(missing, "owner")
@@ -75,11 +81,11 @@ func statusCheckUsage() {
 	if !viper.IsSet("repo") {
 		missing = append(missing, "repo")
 	}
-	if !viper.IsSet("commit") {
-		missing = append(missing, "commit")
+	if !viper.IsSet("commit") && !viper.IsSet("pr_num") {
+		missing = append(missing, "(commit || pr_num)")
 	}
-	if !viper.IsSet("state") {
-		missing = append(missing, "state")
+	if !viper.IsSet("state") && !statusFromReports() {
+		missing = append(missing, "(state || from_junit || from_tap || from_test2json)")
 	}
 	if !viper.IsSet("context") {
 		missing = append(missing, "context")
@@ -93,6 +99,10 @@ func statusCheckUsage() {
 		}
 	}
 
+	invalid += resolveCheckUsage()
+	invalid += clientCheckUsage()
+	invalid += providerCheckUsage()
+
 	if len(missing) > 0 {
 		usage += fmt.Sprintf("MISSING REQUIRED FLAGS: %s\n", strings.Join(missing, ", "))
 	}
@@ -107,37 +117,52 @@ func statusCheckUsage() {
 
 func status(cmd *cobra.Command, args []string) {
 	statusCheckUsage()
-	token := viper.GetString("token")
-	owner := viper.GetString("owner")
-	repo := viper.GetString("repo")
-	commit := viper.GetString("commit")
 	state := strings.ToLower(viper.GetString("state"))
 	desc := viper.GetString("desc")
 	context := viper.GetString("context")
 	url := viper.GetString("url")
 
-	// setup authentication via a github token and create connection
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(oauth2.NoContext, ts)
-	gh := github.NewClient(tc)
-
-	// RepoStatus takes type *string
-	_state := &state
-	_context := &context
-	_desc := &desc
-	_url := &url
-	repo_status := &github.RepoStatus{
-		State:       _state,
-		Description: _desc,
-		Context:     _context,
-		TargetURL:   _url,
+	// derive the state and description from the test reports, unless they are passed in
+	if statusFromReports() {
+		results, err := reportResults(viper.GetString("from_junit"), viper.GetString("from_tap"), viper.GetString("from_test2json"))
+		if err != nil {
+			log.Printf("ERROR: %s\n", err.Error())
+			os.Exit(-1)
+		}
+		if !viper.IsSet("state") {
+			state = results.State()
+		}
+		if !viper.IsSet("desc") {
+			desc = results.String()
+		}
+		log.Printf("Test results: %s\n", results.String())
 	}
-	_, _, err := gh.Repositories.CreateStatus(owner, repo, commit, repo_status)
+
+	p := newProvider()
+	err := postStatus(p, state, desc, context, url)
 	if err != nil {
 		log.Printf("ERROR: %s\n", err.Error())
 		os.Exit(-1)
 	}
 	log.Println("Successfully updated the status!")
 }
+
+// Post the status on 'commit' and the head commit of 'pr_num'
+func postStatus(p Provider, state, desc, context, url string) error {
+	commits, err := ResolveCommits(p)
+	if err != nil {
+		return err
+	}
+	for _, commit := range commits {
+		err := p.CreateStatus(commit, state, desc, context, url)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check if a test report isset to derive the status from
+func statusFromReports() bool {
+	return viper.IsSet("from_junit") || viper.IsSet("from_tap") || viper.IsSet("from_test2json")
+}
@@ -0,0 +1,497 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	S3    string = "s3"
+	SWIFT string = "swift"
+	FILE  string = "file"
+	AZURE string = "azure"
+	GCS   string = "gcs"
+
+	FAIL string = "fail"
+	SKIP string = "skip"
+	WARN string = "warn"
+)
+
+// The extensions of the files served as plain text, whatever their content
+var LOG_EXTENSIONS = []string{".log", ".txt", ".out", ".err", ".stdout", ".stderr", ".trace"}
+
+type Upload struct {
+	Name   string
+	Path   string
+	Obj    string
+	URL    string
+	Failed bool // marked in the comment when 'uploads_on_error' is 'warn'
+}
+
+// An object store the files can be uploaded to
+type Uploader interface {
+	// Create the bucket if needed and make it readable, returns when the uploads stop being available (nil if never).
+	// The 'expire' time is nil when the files are kept forever.
+	Prepare(bucket string, expire *time.Time) (*time.Time, error)
+	// Upload the file at 'path' as the object 'obj' with the 'content_type'
+	Put(bucket, obj, path, content_type string) error
+	// The url to link to an uploaded object
+	URL(bucket, obj string) (string, error)
+}
+
+// The constructors of the uploaders, keyed by the 'uploads_api' they implement
+var uploaders = map[string]func() (Uploader, error){}
+
+// Make an uploader available as an 'uploads_api', called from the init of the file implementing it
+func registerUploader(api string, new_uploader func() (Uploader, error)) {
+	uploaders[api] = new_uploader
+}
+
+// The sorted list of the registered 'uploads_api' values
+func uploaderAPIs() []string {
+	apis := []string{}
+	for api := range uploaders {
+		apis = append(apis, api)
+	}
+	sort.Strings(apis)
+	return apis
+}
+
+// Add the flags used to upload files to an object store
+func uploadsFlags(flags *pflag.FlagSet) {
+	flags.String("uploads_api", "", fmt.Sprintf(
+		"required if 'uploads' isset: api to use to upload to an object store (%s | %s | %s | %s | %s)", S3, SWIFT, AZURE, GCS, FILE))
+	flags.String("uploads_endpoint", "", fmt.Sprintf(
+		"required if 'uploads' isset: object store url endpoint, or the directory to copy the files to when using the '%s' api\n"+
+			"                                  %s: optional, defaults to 'https://<uploads_identity>.blob.core.windows.net'\n"+
+			"                                  %s: optional, defaults to '%s'", FILE, AZURE, GCS, GCS_URL))
+	flags.String("uploads_region", "", fmt.Sprintf(
+		"upload region when using the '%s' api, or the location of the bucket if it is created when using the '%s' api", S3, GCS))
+	flags.String("uploads_identity", "", fmt.Sprintf(`%s: keystone identity as 'tenant:username'
+                                  %s: use the '~/.aws/credentials' file or a 'AWS_ACCESS_KEY_ID' env var
+                                  %s: storage account name
+                                  %s: service account key file, defaults to the application default credentials`, SWIFT, S3, AZURE, GCS))
+	flags.String("uploads_secret", "", fmt.Sprintf(`%s: keystone password
+                                  %s: use the '~/.aws/credentials' file or a 'AWS_SECRET_ACCESS_KEY' env var
+                                  %s: storage account key`, SWIFT, S3, AZURE))
+	flags.StringP("uploads_bucket", "b", "", fmt.Sprintf(
+		"required if 'uploads' isset: bucket to upload the files to (will be made public unless 'uploads_private' isset),\n"+
+			"                                  optional sub directory for '%s'", FILE))
+	flags.String("uploads_base_url", "", fmt.Sprintf(
+		"required when using the '%s' api: url the 'uploads_endpoint' directory is served at", FILE))
+	flags.IntP("uploads_expire", "e", 0, "optional: number of days to keep the uploaded files before they are removed")
+	flags.Int("uploads_concurrency", 4, "optional: number of files to be uploaded concurrently")
+	flags.String("uploads_content_types", "",
+		"optional: comma separated list of 'extension=type' overriding the detected content types (eg: '.html=text/plain')")
+	flags.Int("uploads_segment_threshold", 1024, fmt.Sprintf(
+		"optional: size in MB above which files are uploaded in segments (%s multipart uploads or %s large objects)", S3, SWIFT))
+	flags.Int("uploads_segment_size", 100, "optional: size in MB of the segments of the files uploaded in segments, each retried on its own")
+	flags.String("uploads_on_error", FAIL, fmt.Sprintf(
+		"optional: how failed uploads are handled, exit without commenting, leave them out or mark them (%s | %s | %s)", FAIL, SKIP, WARN))
+	flags.Bool("uploads_private", false, "optional: leave the bucket private and link the files with signed urls valid for 'uploads_expire' days")
+}
+
+// Bind the flags used to upload files to an object store
+func bindUploadsFlags(flags *pflag.FlagSet) {
+	viper.BindPFlag("uploads_api", flags.Lookup("uploads_api"))
+	viper.BindPFlag("uploads_endpoint", flags.Lookup("uploads_endpoint"))
+	viper.BindPFlag("uploads_region", flags.Lookup("uploads_region"))
+	viper.BindPFlag("uploads_identity", flags.Lookup("uploads_identity"))
+	viper.BindPFlag("uploads_secret", flags.Lookup("uploads_secret"))
+	viper.BindPFlag("uploads_bucket", flags.Lookup("uploads_bucket"))
+	viper.BindPFlag("uploads_base_url", flags.Lookup("uploads_base_url"))
+	viper.BindPFlag("uploads_expire", flags.Lookup("uploads_expire"))
+	viper.BindPFlag("uploads_concurrency", flags.Lookup("uploads_concurrency"))
+	viper.BindPFlag("uploads_content_types", flags.Lookup("uploads_content_types"))
+	viper.BindPFlag("uploads_segment_threshold", flags.Lookup("uploads_segment_threshold"))
+	viper.BindPFlag("uploads_segment_size", flags.Lookup("uploads_segment_size"))
+	viper.BindPFlag("uploads_private", flags.Lookup("uploads_private"))
+	viper.BindPFlag("uploads_on_error", flags.Lookup("uploads_on_error"))
+}
+
+// Validate the flags used to upload files, returns the missing flags and the errors to display
+func uploadsCheckUsage() ([]string, string) {
+	missing := []string{}
+	invalid := ""
+
+	if !viper.IsSet("uploads_api") {
+		missing = append(missing, "uploads_api")
+	}
+	api := strings.ToLower(viper.GetString("uploads_api"))
+	if !viper.IsSet("uploads_endpoint") && api != AZURE && api != GCS {
+		missing = append(missing, "uploads_endpoint")
+	}
+	if !viper.IsSet("uploads_bucket") && api != FILE {
+		missing = append(missing, "uploads_bucket")
+	}
+
+	if _, ok := uploaders[api]; !ok {
+		invalid += fmt.Sprintf("ERROR: The 'uploads_api' flag must be one of: %s\n", strings.Join(uploaderAPIs(), ", "))
+	}
+	if api == SWIFT && !viper.IsSet("uploads_identity") {
+		missing = append(missing, "uploads_identity")
+	}
+	if api == SWIFT && !viper.IsSet("uploads_secret") {
+		missing = append(missing, "uploads_secret")
+	}
+	if api == SWIFT && viper.IsSet("uploads_identity") {
+		if !strings.Contains(viper.GetString("uploads_identity"), ":") {
+			invalid += fmt.Sprintf("ERROR: The 'uploads_identity' flag for '%s' is formatted as 'tenant:username'\n", SWIFT)
+		}
+	}
+	if api == AZURE && !viper.IsSet("uploads_identity") {
+		missing = append(missing, "uploads_identity")
+	}
+	if api == AZURE && !viper.IsSet("uploads_secret") {
+		missing = append(missing, "uploads_secret")
+	}
+	if api == S3 && !viper.IsSet("uploads_region") {
+		missing = append(missing, "uploads_region")
+		invalid += fmt.Sprintf("ERROR: The 'uploads_region' flag is required when using the '%s' api for 'uploads'\n", S3)
+	}
+	if api == FILE && !viper.IsSet("uploads_base_url") {
+		missing = append(missing, "uploads_base_url")
+	}
+	if viper.GetBool("uploads_private") && viper.GetInt("uploads_expire") == 0 {
+		missing = append(missing, "uploads_expire")
+		invalid += "ERROR: The 'uploads_expire' flag is required to sign the urls when 'uploads_private' isset\n"
+	}
+	if viper.GetBool("uploads_private") && api == FILE {
+		invalid += fmt.Sprintf("ERROR: The 'uploads_private' flag can not be used with the '%s' api\n", FILE)
+	}
+	if _, err := contentTypeOverrides(); err != nil {
+		invalid += fmt.Sprintf("ERROR: The 'uploads_content_types' flag %s\n", err.Error())
+	}
+	if size := viper.GetInt("uploads_segment_size"); size < 5 || size > 5120 {
+		invalid += "ERROR: The 'uploads_segment_size' flag must be between 5 and 5120 MB\n"
+	}
+	switch strings.ToLower(viper.GetString("uploads_on_error")) {
+	case FAIL, SKIP, WARN:
+	default:
+		invalid += fmt.Sprintf("ERROR: The 'uploads_on_error' flag must be one of: %s, %s, %s\n", FAIL, SKIP, WARN)
+	}
+
+	return missing, invalid
+}
+
+// Upload the files via the 'uploads_api', returns an error if some failed and 'uploads_on_error' is 'fail'
+func (c *CommentBody) Upload() error {
+	api := strings.ToLower(viper.GetString("uploads_api"))
+	new_uploader, ok := uploaders[api]
+	if !ok {
+		return fmt.Errorf("The 'uploads_api' flag must be one of: %s", strings.Join(uploaderAPIs(), ", "))
+	}
+	uploader, err := new_uploader()
+	if err != nil {
+		log.Printf("ERROR: %s\n", err.Error())
+		return c.uploadsFailed(c.failAll())
+	}
+	return c.UploadWith(uploader)
+}
+
+// Upload the files concurrently with an uploader and set their urls, the failures are handled based on 'uploads_on_error'.
+func (c *CommentBody) UploadWith(uploader Uploader) error {
+	bucket := viper.GetString("uploads_bucket")
+	expires := viper.GetInt("uploads_expire")
+	var expire *time.Time
+	if expires != 0 {
+		// truncated to midnight on GMT time, as required by the S3 lifecycle rules
+		expire_time := time.Now().Truncate(time.Duration(24) * time.Hour).Add(time.Duration(expires+1) * 24 * time.Hour)
+		expire = &expire_time
+	}
+
+	until, err := uploader.Prepare(bucket, expire)
+	if err != nil {
+		log.Printf("ERROR: %s\n", err.Error())
+		return c.uploadsFailed(c.failAll())
+	}
+	c.UploadsExpire = until
+	c.UploadsPrivate = viper.GetBool("uploads_private")
+
+	if bucket != "" {
+		log.Printf("Using bucket: %s\n", bucket)
+	}
+	log.Println("Starting upload...  This can take a while, go get a coffee.  :)")
+
+	// do the actual upload
+	process_upload := func(u *Upload) error {
+		if len(u.Obj) > 0 {
+			log.Printf("  started: %s\n", u.Obj)
+			content_type := contentType(u.Path)
+			err := retry(fmt.Sprintf("Uploading object '%s'", u.Obj), func() error {
+				return uploader.Put(bucket, u.Obj, u.Path, content_type)
+			})
+			if err != nil {
+				log.Printf("ERROR: Problem uploading object '%s'\n", u.Obj)
+				log.Println(err)
+				return err
+			}
+			log.Printf(" uploaded: %s\n", u.Obj)
+			u.URL, err = uploader.URL(bucket, u.Obj)
+			if err != nil {
+				log.Printf("ERROR: Problem building the url of object '%s'\n", u.Obj)
+				log.Println(err)
+				return err
+			}
+		}
+		return nil
+	}
+
+	// setup 'process_upload' concurrency controls
+	uploadc := make(chan *Upload)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := []*Upload{}
+	// setup the number of concurrent goroutine workers
+	for i := 0; i < viper.GetInt("uploads_concurrency"); i++ {
+		wg.Add(1)
+		go func() {
+			for u := range uploadc {
+				if process_upload(u) != nil {
+					u.Failed = true
+					u.URL = ""
+					mu.Lock()
+					failed = append(failed, u)
+					mu.Unlock()
+				}
+			}
+			wg.Done()
+		}()
+	}
+	// feed the uploads into the concurrent goroutines to be uploaded
+	for dir, uploads := range c.Uploads { // loop through the map
+		for i, _ := range uploads { // loop through each dir list
+			if c.Uploads[dir][i].Failed { // the file could not be read
+				mu.Lock()
+				failed = append(failed, &c.Uploads[dir][i])
+				mu.Unlock()
+				continue
+			}
+			uploadc <- &c.Uploads[dir][i] // point to the object so we can modify it inline
+		}
+	}
+	close(uploadc)
+	wg.Wait()
+	return c.uploadsFailed(failed)
+}
+
+// The content type of a file from 'uploads_content_types', its extension or its content.
+// Log like files are served as utf-8 text so they are viewable in the browser.
+func contentType(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	overrides, _ := contentTypeOverrides()
+	if content_type, ok := overrides[ext]; ok {
+		return content_type
+	}
+	for _, log_ext := range LOG_EXTENSIONS {
+		if ext == log_ext {
+			return "text/plain; charset=utf-8"
+		}
+	}
+	if content_type := mime.TypeByExtension(ext); content_type != "" {
+		return content_type
+	}
+
+	// sniff the first bytes of the file
+	f, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	return http.DetectContentType(head[:n])
+}
+
+// Parse the 'uploads_content_types' flag into a map of lower case extensions, including the '.', to content types
+func contentTypeOverrides() (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, item := range strings.Split(viper.GetString("uploads_content_types"), ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("is formatted as 'extension=type', got '%s'", item)
+		}
+		ext := strings.ToLower(strings.TrimSpace(parts[0]))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		overrides[ext] = strings.TrimSpace(parts[1])
+	}
+	return overrides, nil
+}
+
+// The key of an object in the stores which remove the expiring objects with a lifecycle rule on the 'upload-expires/' prefix
+func expiringKey(obj string, expire *time.Time) string {
+	if expire == nil {
+		return obj
+	}
+	return "upload-expires/" + obj
+}
+
+// The size in bytes above which files are uploaded in segments
+func uploadsSegmentThreshold() int64 {
+	return int64(viper.GetInt("uploads_segment_threshold")) * 1024 * 1024
+}
+
+// The size in bytes of the segments of large files
+func uploadsSegmentSize() int64 {
+	return int64(viper.GetInt("uploads_segment_size")) * 1024 * 1024
+}
+
+// Mark all the uploads as failed, when nothing could be uploaded
+func (c *CommentBody) failAll() []*Upload {
+	failed := []*Upload{}
+	for dir, uploads := range c.Uploads {
+		for i := range uploads {
+			c.Uploads[dir][i].Failed = true
+			failed = append(failed, &c.Uploads[dir][i])
+		}
+	}
+	return failed
+}
+
+// Summarize the failed uploads and handle them based on 'uploads_on_error'
+func (c *CommentBody) uploadsFailed(failed []*Upload) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Path < failed[j].Path })
+	log.Printf("ERROR: Failed to upload %d file(s):\n", len(failed))
+	for _, u := range failed {
+		log.Printf("  %s\n", u.Path)
+	}
+
+	switch strings.ToLower(viper.GetString("uploads_on_error")) {
+	case SKIP:
+		log.Println("WARNING: The failed uploads are left out.")
+		for dir, uploads := range c.Uploads {
+			kept := []Upload{}
+			for _, u := range uploads {
+				if !u.Failed {
+					kept = append(kept, u)
+				}
+			}
+			if len(kept) > 0 {
+				c.Uploads[dir] = kept
+			} else {
+				delete(c.Uploads, dir)
+			}
+		}
+	case WARN:
+		log.Println("WARNING: The failed uploads are marked as failed.")
+	default:
+		return fmt.Errorf("%d file(s) failed to upload", len(failed))
+	}
+	return nil
+}
+
+// Populates the Uploads section of the CommentBody struct from a comma separated list of files or directories
+func (c *CommentBody) PopulateUploads(uploads string) {
+	c.Uploads = make(map[string][]Upload)
+
+	// local code reuse for populating the 'Uploads' field, the files which can not be read are marked as failed
+	populate_upload := func(path string, failed bool) {
+		dir := filepath.Dir(path)
+		name := filepath.Base(path)
+		obj := strings.Replace(path, "..", "up", -1) // replace '..' in the obj path
+		obj = strings.TrimPrefix(obj, string(os.PathSeparator))
+		obj = filepath.ToSlash(obj) // fix windows paths
+
+		if _, exists := c.Uploads[dir]; exists {
+			c.Uploads[dir] = append(c.Uploads[dir], Upload{
+				Name:   name,
+				Path:   path,
+				Obj:    obj,
+				Failed: failed,
+			})
+		} else {
+			c.Uploads[dir] = []Upload{
+				{
+					Name:   name,
+					Path:   path,
+					Obj:    obj,
+					Failed: failed,
+				},
+			}
+		}
+	}
+
+	items := strings.Split(uploads, ",")
+	for _, item := range items {
+		clean := filepath.Clean(strings.TrimSpace(item))
+		f, err := os.Open(clean)
+		if err != nil {
+			log.Printf("ERROR: Failed to open upload file '%s'.\n", clean)
+			populate_upload(clean, true)
+			continue
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			log.Printf("ERROR: Failed to stat upload file '%s'.\n", clean)
+			populate_upload(clean, true)
+			continue
+		}
+		switch mode := fi.Mode(); {
+		// Process a directory
+		case mode.IsDir():
+			err = filepath.Walk(clean, func(path string, info os.FileInfo, walk_err error) (err error) {
+				sub_clean := filepath.Clean(strings.TrimSpace(path))
+				if walk_err != nil {
+					log.Printf("ERROR: Failed to read upload path '%s'.\n", sub_clean)
+					populate_upload(sub_clean, true)
+					return nil
+				}
+				if info.Mode().IsRegular() {
+					populate_upload(sub_clean, false)
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("ERROR: Walking upload directory '%s'.\n", clean)
+			}
+		// Process a regular file
+		case mode.IsRegular():
+			populate_upload(clean, false)
+		}
+	}
+}
+
+// Escape each part of an object path, keeping the '/' separators
+func escapeObj(obj string) string {
+	parts := strings.Split(obj, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
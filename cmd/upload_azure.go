@@ -0,0 +1,287 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const AZURE_VERSION string = "2020-10-02" // the version of the Blob service api (and of the SAS links)
+
+// A connection to the Azure Blob service (or the Azurite emulator) authenticated with a shared key
+type AzureBlob struct {
+	Endpoint *url.URL
+	Account  string
+	Key      []byte
+	Client   *http.Client
+}
+
+// An error response from the Azure Blob service
+type azureError struct {
+	StatusCode int
+	Code       string // the 'x-ms-error-code' header, eg: 'ContainerAlreadyExists'
+	Message    string
+}
+
+func (e *azureError) Error() string {
+	return e.Message
+}
+
+func init() {
+	registerUploader(AZURE, newAzureUploader)
+}
+
+// Uploads the files as blobs in the 'uploads_bucket' container of the 'uploads_identity' storage account.
+// The container is made public if the storage account allows it, otherwise the urls are SAS links.
+type AzureUploader struct {
+	Conn      *AzureBlob
+	SAS       bool // always used if the uploads are private
+	SASExpiry time.Time
+}
+
+func newAzureUploader() (Uploader, error) {
+	conn, err := azureConnection()
+	if err != nil {
+		return nil, err
+	}
+	return &AzureUploader{Conn: conn, SAS: viper.GetBool("uploads_private")}, nil
+}
+
+// Create the container with public access to the blobs, unless the uploads are private or the storage account does not allow it
+func (u *AzureUploader) Prepare(bucket string, expire *time.Time) (*time.Time, error) {
+	if expire != nil {
+		log.Printf("WARNING: The '%s' api can not remove expired files, add a lifecycle management rule "+
+			"to the storage account to remove them.\n", AZURE)
+	}
+
+	var err error
+	container_query := url.Values{"restype": {"container"}}
+	if !u.SAS {
+		// create the container with public access to the blobs if it does not already exist
+		public := map[string]string{"x-ms-blob-public-access": "blob"}
+		err = u.Conn.Request("PUT", bucket, container_query, public, nil, 0)
+		if az_err, ok := err.(*azureError); ok && az_err.Code == "ContainerAlreadyExists" {
+			// update the acls for the container
+			err = u.Conn.Request("PUT", bucket, url.Values{"restype": {"container"}, "comp": {"acl"}}, public, nil, 0)
+		}
+		if az_err, ok := err.(*azureError); ok && az_err.Code == "PublicAccessNotPermitted" {
+			log.Printf("WARNING: The storage account '%s' does not allow public access, using SAS links instead.\n", u.Conn.Account)
+			u.SAS = true
+		}
+	}
+	if u.SAS {
+		// create the container without changing the access of an existing one
+		err = u.Conn.Request("PUT", bucket, container_query, nil, nil, 0)
+		if az_err, ok := err.(*azureError); ok && az_err.Code == "ContainerAlreadyExists" {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Problem creating bucket '%s'\n%s", bucket, err.Error())
+	}
+
+	// the SAS links stop working when the files expire, or after a year if they do not
+	if u.SAS {
+		u.SASExpiry = time.Now().AddDate(1, 0, 0)
+		if expire != nil {
+			u.SASExpiry = *expire
+		}
+		return &u.SASExpiry, nil
+	}
+	return expire, nil
+}
+
+func (u *AzureUploader) Put(bucket, obj, path, content_type string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"x-ms-blob-type": "BlockBlob", "x-ms-blob-content-type": content_type}
+	return u.Conn.Request("PUT", bucket+"/"+obj, nil, headers, f, info.Size())
+}
+
+func (u *AzureUploader) URL(bucket, obj string) (string, error) {
+	if u.SAS {
+		return u.Conn.SASURL(bucket+"/"+obj, u.SASExpiry).String(), nil
+	}
+	return u.Conn.URL(bucket + "/" + obj).String(), nil
+}
+
+// Connect to the storage account 'uploads_identity' with the key 'uploads_secret', at 'uploads_endpoint' if it isset.
+// The Azurite emulator is used with an endpoint including the account, like 'http://127.0.0.1:10000/devstoreaccount1'.
+func azureConnection() (*AzureBlob, error) {
+	account := viper.GetString("uploads_identity")
+	key, err := base64.StdEncoding.DecodeString(viper.GetString("uploads_secret"))
+	if err != nil {
+		return nil, fmt.Errorf("the 'uploads_secret' flag for '%s' must be the base64 encoded account key: %s", AZURE, err.Error())
+	}
+	endpoint := fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	if viper.IsSet("uploads_endpoint") {
+		endpoint = viper.GetString("uploads_endpoint")
+	}
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("the 'uploads_endpoint' flag is not a valid url: %s", err.Error())
+	}
+	return &AzureBlob{
+		Endpoint: u,
+		Account:  account,
+		Key:      key,
+		Client:   retryClient(),
+	}, nil
+}
+
+// The url of a container or blob path
+func (a *AzureBlob) URL(path string) *url.URL {
+	u := *a.Endpoint
+	u.Path = a.Endpoint.Path + "/" + path
+	u.RawPath = ""
+	return &u
+}
+
+// Send a request signed with the shared key of the storage account
+func (a *AzureBlob) Request(method, path string, query url.Values, headers map[string]string, body io.Reader, length int64) error {
+	u := a.URL(path)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", AZURE_VERSION)
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", a.Account, a.sign(a.stringToSign(req))))
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &azureError{
+			StatusCode: resp.StatusCode,
+			Code:       resp.Header.Get("x-ms-error-code"),
+			Message:    fmt.Sprintf("%s %s: %s %s", method, u.Path, resp.Status, strings.TrimSpace(string(msg))),
+		}
+	}
+	return nil
+}
+
+// A read only SAS link to a blob, valid until the expiry
+func (a *AzureBlob) SASURL(path string, expiry time.Time) *url.URL {
+	se := expiry.UTC().Format("2006-01-02T15:04:05Z")
+	resource := fmt.Sprintf("/blob/%s/%s", a.Account, path)
+	string_to_sign := strings.Join([]string{
+		"r",                // signed permissions
+		"",                 // signed start
+		se,                 // signed expiry
+		resource,           // canonicalized resource
+		"",                 // signed identifier
+		"",                 // signed ip
+		"",                 // signed protocol
+		AZURE_VERSION,      // signed version
+		"b",                // signed resource
+		"",                 // signed snapshot time
+		"", "", "", "", "", // response headers
+	}, "\n")
+	u := a.URL(path)
+	u.RawQuery = url.Values{
+		"sv":  {AZURE_VERSION},
+		"sr":  {"b"},
+		"sp":  {"r"},
+		"se":  {se},
+		"sig": {a.sign(string_to_sign)},
+	}.Encode()
+	return u
+}
+
+func (a *AzureBlob) sign(s string) string {
+	mac := hmac.New(sha256.New, a.Key)
+	mac.Write([]byte(s))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Build the string to sign for the shared key authorization of a request
+func (a *AzureBlob) stringToSign(req *http.Request) string {
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	parts := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // the date is passed in 'x-ms-date'
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+
+	// the canonicalized headers
+	ms_headers := []string{}
+	for k := range req.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-ms-") {
+			ms_headers = append(ms_headers, strings.ToLower(k))
+		}
+	}
+	sort.Strings(ms_headers)
+	for _, k := range ms_headers {
+		parts = append(parts, fmt.Sprintf("%s:%s", k, strings.TrimSpace(req.Header.Get(k))))
+	}
+
+	// the canonicalized resource, which includes the account twice with path style urls (like Azurite)
+	resource := fmt.Sprintf("/%s%s", a.Account, req.URL.EscapedPath())
+	query := req.URL.Query()
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		resource += fmt.Sprintf("\n%s:%s", strings.ToLower(k), strings.Join(values, ","))
+	}
+	return strings.Join(append(parts, resource), "\n")
+}
@@ -0,0 +1,171 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// The well known account and key of the Azurite storage emulator
+const (
+	AZURITE_ACCOUNT string = "devstoreaccount1"
+	AZURITE_KEY     string = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+func azuriteBlob(t *testing.T, endpoint string) *AzureBlob {
+	key, err := base64.StdEncoding.DecodeString(AZURITE_KEY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AzureBlob{Endpoint: u, Account: AZURITE_ACCOUNT, Key: key, Client: retryClient()}
+}
+
+// The string to sign, field by field as listed in the Azure Storage docs on the Shared Key authorization
+func specStringToSign(verb, content_length string, canonicalized_headers []string, canonicalized_resource string) string {
+	fields := []string{
+		verb,
+		"",             // Content-Encoding
+		"",             // Content-Language
+		content_length, // Content-Length, empty when zero
+		"",             // Content-MD5
+		"",             // Content-Type
+		"",             // Date, empty as 'x-ms-date' is set
+		"",             // If-Modified-Since
+		"",             // If-Match
+		"",             // If-None-Match
+		"",             // If-Unmodified-Since
+		"",             // Range
+	}
+	fields = append(fields, canonicalized_headers...)
+	return strings.Join(append(fields, canonicalized_resource), "\n")
+}
+
+// The HMAC-SHA256 signature of a string with a base64 encoded key
+func hmacSignature(t *testing.T, encoded_key, s string) string {
+	key, err := base64.StdEncoding.DecodeString(encoded_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAzureSign(t *testing.T) {
+	// test case 2 of RFC 4231, the expected digest is 5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843
+	a := &AzureBlob{Key: []byte("Jefe")}
+	if signature := a.sign("what do ya want for nothing?"); signature != "W9zBRr9gdU5qBCQmCJV1x1oAPwidJzmDnexYuWTsOEM=" {
+		t.Errorf("unexpected signature: %s", signature)
+	}
+}
+
+func TestAzureStringToSign(t *testing.T) {
+	date := "Fri, 16 Oct 2026 12:00:00 GMT"
+	cases := []struct {
+		endpoint string
+		method   string
+		path     string
+		query    url.Values
+		headers  map[string]string
+		length   int64
+		expected string
+	}{
+		{
+			// the example of the Azure Storage docs, getting the metadata of a container
+			"https://myaccount.blob.core.windows.net", "GET", "mycontainer",
+			url.Values{"restype": {"container"}, "comp": {"metadata"}, "timeout": {"20"}},
+			map[string]string{"x-ms-date": "Fri, 26 Jun 2015 23:39:12 GMT", "x-ms-version": "2015-02-21"}, 0,
+			"GET\n\n\n\n\n\n\n\n\n\n\n\nx-ms-date:Fri, 26 Jun 2015 23:39:12 GMT\nx-ms-version:2015-02-21\n" +
+				"/myaccount/mycontainer\ncomp:metadata\nrestype:container\ntimeout:20",
+		},
+		{
+			"https://devstoreaccount1.blob.core.windows.net", "PUT", "uploads/logs/run log.txt", nil,
+			map[string]string{"x-ms-blob-type": "BlockBlob", "x-ms-blob-content-type": "text/plain; charset=utf-8",
+				"x-ms-date": date, "x-ms-version": AZURE_VERSION}, 11,
+			specStringToSign("PUT", "11", []string{
+				"x-ms-blob-content-type:text/plain; charset=utf-8",
+				"x-ms-blob-type:BlockBlob",
+				"x-ms-date:" + date,
+				"x-ms-version:" + AZURE_VERSION,
+			}, "/devstoreaccount1/uploads/logs/run%20log.txt"),
+		},
+		{
+			"https://devstoreaccount1.blob.core.windows.net", "PUT", "uploads",
+			url.Values{"restype": {"container"}, "comp": {"acl"}},
+			map[string]string{"x-ms-blob-public-access": "blob", "x-ms-date": date, "x-ms-version": AZURE_VERSION}, 0,
+			specStringToSign("PUT", "", []string{
+				"x-ms-blob-public-access:blob",
+				"x-ms-date:" + date,
+				"x-ms-version:" + AZURE_VERSION,
+			}, "/devstoreaccount1/uploads\ncomp:acl\nrestype:container"),
+		},
+	}
+	for _, c := range cases {
+		a := azuriteBlob(t, c.endpoint)
+		a.Account = strings.SplitN(a.Endpoint.Host, ".", 2)[0]
+		u := a.URL(c.path)
+		u.RawQuery = c.query.Encode()
+		req, _ := http.NewRequest(c.method, u.String(), nil)
+		req.ContentLength = c.length
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+		if string_to_sign := a.stringToSign(req); string_to_sign != c.expected {
+			t.Errorf("%s %s: expected the string to sign\n%q\ngot\n%q", c.method, c.path, c.expected, string_to_sign)
+		}
+	}
+}
+
+func TestAzureAuthorizationHeader(t *testing.T) {
+	setConfig(t, "retries", 0)
+	var authorization, date, resource string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		date = r.Header.Get("x-ms-date")
+		resource = r.URL.EscapedPath()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	// Azurite uses path style urls, so the account is in the path of the endpoint
+	a := azuriteBlob(t, srv.URL+"/"+AZURITE_ACCOUNT)
+	err := a.Request("PUT", "uploads/run.log", nil, map[string]string{"x-ms-blob-type": "BlockBlob"}, strings.NewReader("hello"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resource != "/devstoreaccount1/uploads/run.log" {
+		t.Errorf("unexpected path: %s", resource)
+	}
+	string_to_sign := specStringToSign("PUT", "5", []string{
+		"x-ms-blob-type:BlockBlob",
+		"x-ms-date:" + date,
+		"x-ms-version:" + AZURE_VERSION,
+	}, "/devstoreaccount1/devstoreaccount1/uploads/run.log")
+	expected := "SharedKey devstoreaccount1:" + hmacSignature(t, AZURITE_KEY, string_to_sign)
+	if authorization != expected {
+		t.Errorf("expected the authorization '%s', got '%s'", expected, authorization)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	registerUploader(FILE, newFileUploader)
+}
+
+// Copies the files into the 'uploads_endpoint' directory (in the 'uploads_bucket' sub directory if it isset),
+// which is expected to be served at 'uploads_base_url' by a web server.
+type FileUploader struct {
+	Dir     string
+	BaseURL string
+}
+
+func newFileUploader() (Uploader, error) {
+	return &FileUploader{
+		Dir:     viper.GetString("uploads_endpoint"),
+		BaseURL: strings.TrimRight(viper.GetString("uploads_base_url"), "/"),
+	}, nil
+}
+
+// Create the directory, the files are never expired
+func (u *FileUploader) Prepare(bucket string, expire *time.Time) (*time.Time, error) {
+	if expire != nil {
+		log.Printf("WARNING: The '%s' api does not expire files, 'uploads_expire' is ignored.\n", FILE)
+	}
+	dir := u.path(bucket, "")
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Problem creating directory '%s'\n%s", dir, err.Error())
+	}
+	log.Printf("Using directory: %s\n", dir)
+	return nil, nil
+}
+
+// Copy a file to its object path within the directory, the web server serving it sets the content type
+func (u *FileUploader) Put(bucket, obj, path, content_type string) error {
+	dst := u.path(bucket, obj)
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if close_err := out.Close(); err == nil {
+		err = close_err
+	}
+	return err
+}
+
+func (u *FileUploader) URL(bucket, obj string) (string, error) {
+	base_url := u.BaseURL
+	if bucket != "" {
+		base_url += "/" + strings.Trim(bucket, "/")
+	}
+	return base_url + "/" + escapeObj(obj), nil
+}
+
+// The local path of an object
+func (u *FileUploader) path(bucket, obj string) string {
+	return filepath.Join(u.Dir, filepath.FromSlash(bucket), filepath.FromSlash(obj))
+}
@@ -0,0 +1,283 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/spf13/viper"
+)
+
+const GCS_URL string = "https://storage.googleapis.com"
+
+// A connection to the Google Cloud Storage JSON api (or a stand in like fake-gcs-server)
+type GCSStorage struct {
+	Endpoint    string
+	Project     string
+	ClientEmail string          // the service account used to sign urls
+	Key         *rsa.PrivateKey // nil if the credentials can not sign urls
+	Client      *http.Client
+}
+
+type gcsBucket struct {
+	Name             string `json:"name"`
+	Location         string `json:"location,omitempty"`
+	IAMConfiguration *struct {
+		UniformBucketLevelAccess struct {
+			Enabled bool `json:"enabled"`
+		} `json:"uniformBucketLevelAccess"`
+	} `json:"iamConfiguration,omitempty"`
+	Lifecycle *gcsLifecycle `json:"lifecycle,omitempty"`
+}
+
+// The lifecycle rules are kept as decoded so the rules of others are sent back unchanged
+type gcsLifecycle struct {
+	Rule []map[string]interface{} `json:"rule"`
+}
+
+func init() {
+	registerUploader(GCS, newGCSUploader)
+}
+
+// Uploads the files as objects in the 'uploads_bucket' bucket, authenticated with the 'uploads_identity' service account.
+// The objects are made public unless the bucket uses uniform bucket-level access, in which case the urls are signed.
+type GCSUploader struct {
+	Conn         *GCSStorage
+	Expire       *time.Time
+	Signed       bool // always used if the uploads are private
+	SignedExpiry time.Time
+}
+
+func newGCSUploader() (Uploader, error) {
+	conn, err := gcsConnection()
+	if err != nil {
+		return nil, err
+	}
+	return &GCSUploader{Conn: conn, Signed: viper.GetBool("uploads_private")}, nil
+}
+
+// Create the bucket if it does not exist and expire the objects with a lifecycle rule
+func (u *GCSUploader) Prepare(bucket string, expire *time.Time) (*time.Time, error) {
+	u.Expire = expire
+
+	// does bucket exist?
+	b := &gcsBucket{}
+	err := u.Conn.request("GET", fmt.Sprintf("/storage/v1/b/%s", url.PathEscape(bucket)), nil, b)
+	if api_err, ok := err.(*apiError); ok && api_err.StatusCode == http.StatusNotFound { // bucket did not exist, create it...
+		b = &gcsBucket{Name: bucket, Location: viper.GetString("uploads_region")}
+		err = u.Conn.request("POST", fmt.Sprintf("/storage/v1/b?project=%s", url.QueryEscape(u.Conn.Project)), b, b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Problem creating bucket '%s'\n%s", bucket, err.Error())
+	}
+
+	// object acls can not be used with uniform bucket-level access, so signed urls are used instead
+	if !u.Signed && b.IAMConfiguration != nil && b.IAMConfiguration.UniformBucketLevelAccess.Enabled {
+		log.Printf("WARNING: The bucket '%s' uses uniform bucket-level access, using signed urls instead.\n", bucket)
+		u.Signed = true
+	}
+	if u.Signed && u.Conn.Key == nil {
+		return nil, fmt.Errorf("Signing urls requires the service account key file as the 'uploads_identity'.")
+	}
+
+	// set the expire time for the bucket, keeping its other lifecycle rules
+	if expire != nil {
+		lifecycle, changed := gcsExpireRule(b.Lifecycle, viper.GetInt("uploads_expire"))
+		if changed {
+			err = u.Conn.request("PATCH", fmt.Sprintf("/storage/v1/b/%s", url.PathEscape(bucket)),
+				map[string]interface{}{"lifecycle": lifecycle}, nil)
+			if err != nil {
+				return nil, fmt.Errorf("Problem updating lifecycle to automatically expire objects in bucket '%s'\n%s", bucket, err.Error())
+			}
+		}
+	}
+
+	// the signed urls stop working when the files expire, or after a year if they do not
+	if u.Signed {
+		u.SignedExpiry = time.Now().AddDate(1, 0, 0)
+		if expire != nil {
+			u.SignedExpiry = *expire
+		}
+		return &u.SignedExpiry, nil
+	}
+	return expire, nil
+}
+
+// Upload the object, publicly readable unless the urls are signed
+func (u *GCSUploader) Put(bucket, obj, path, content_type string) error {
+	obj = expiringKey(obj, u.Expire)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	query := url.Values{"uploadType": {"media"}, "name": {obj}}
+	if !u.Signed {
+		query.Set("predefinedAcl", "publicRead")
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s",
+		u.Conn.Endpoint, url.PathEscape(bucket), query.Encode()), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", content_type)
+	resp, err := u.Conn.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return &apiError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg))),
+		}
+	}
+	return nil
+}
+
+// Add the rule deleting the expiring objects to the lifecycle, or update its age, returns false if it is already there
+func gcsExpireRule(lifecycle *gcsLifecycle, age int) (*gcsLifecycle, bool) {
+	rule := map[string]interface{}{
+		"action":    map[string]interface{}{"type": "Delete"},
+		"condition": map[string]interface{}{"age": age, "matchesPrefix": []string{"upload-expires/"}},
+	}
+	if lifecycle == nil {
+		return &gcsLifecycle{Rule: []map[string]interface{}{rule}}, true
+	}
+	for i, r := range lifecycle.Rule {
+		action, _ := r["action"].(map[string]interface{})
+		condition, _ := r["condition"].(map[string]interface{})
+		prefixes, _ := condition["matchesPrefix"].([]interface{})
+		if action["type"] != "Delete" || len(prefixes) != 1 || prefixes[0] != "upload-expires/" {
+			continue
+		}
+		if r_age, ok := condition["age"].(float64); ok && int(r_age) == age && len(condition) == 2 {
+			return lifecycle, false
+		}
+		lifecycle.Rule[i] = rule
+		return lifecycle, true
+	}
+	lifecycle.Rule = append(lifecycle.Rule, rule)
+	return lifecycle, true
+}
+
+func (u *GCSUploader) URL(bucket, obj string) (string, error) {
+	obj = expiringKey(obj, u.Expire)
+	if u.Signed {
+		return u.Conn.SignedURL(bucket, obj, u.SignedExpiry)
+	}
+	return u.Conn.URL(bucket, obj), nil
+}
+
+// Connect with the service account key file 'uploads_identity', or the application default credentials if it is not set.
+// Without any credentials, the requests are not authenticated when using a stand in at 'uploads_endpoint'.
+func gcsConnection() (*GCSStorage, error) {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, retryClient())
+	conn := &GCSStorage{
+		Endpoint: GCS_URL,
+		Client:   retryClient(),
+	}
+	if viper.IsSet("uploads_endpoint") {
+		conn.Endpoint = strings.TrimRight(viper.GetString("uploads_endpoint"), "/")
+	}
+
+	var creds *google.Credentials
+	var err error
+	if viper.IsSet("uploads_identity") {
+		key_file := viper.GetString("uploads_identity")
+		data, read_err := ioutil.ReadFile(key_file)
+		if read_err != nil {
+			return nil, fmt.Errorf("reading the service account key file '%s': %s", key_file, read_err.Error())
+		}
+		creds, err = google.CredentialsFromJSON(ctx, data, "https://www.googleapis.com/auth/devstorage.full_control")
+		if err != nil {
+			return nil, fmt.Errorf("parsing the service account key file '%s': %s", key_file, err.Error())
+		}
+
+		// keep the private key to sign urls
+		key := &struct {
+			ClientEmail string `json:"client_email"`
+			PrivateKey  string `json:"private_key"`
+		}{}
+		if json.Unmarshal(data, key) == nil && key.PrivateKey != "" {
+			conn.ClientEmail = key.ClientEmail
+			conn.Key, err = parseRSAKey([]byte(key.PrivateKey))
+			if err != nil {
+				return nil, fmt.Errorf("parsing the private key of '%s': %s", key_file, err.Error())
+			}
+		}
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/devstorage.full_control")
+		if err != nil && !viper.IsSet("uploads_endpoint") {
+			return nil, fmt.Errorf("finding the default Google credentials: %s", err.Error())
+		}
+	}
+	if creds != nil {
+		conn.Project = creds.ProjectID
+		conn.Client = oauth2.NewClient(ctx, creds.TokenSource)
+	}
+	return conn, nil
+}
+
+// Send a json request to the api
+func (g *GCSStorage) request(method, path string, body, v interface{}) error {
+	_, err := apiRequest(g.Client, method, g.Endpoint+path, func(req *http.Request) {}, body, v)
+	return err
+}
+
+// The public url of an object
+func (g *GCSStorage) URL(bucket, obj string) string {
+	return fmt.Sprintf("%s/%s/%s", g.Endpoint, bucket, escapeObj(obj))
+}
+
+// A V2 signed url to read an object until the expiry
+func (g *GCSStorage) SignedURL(bucket, obj string, expiry time.Time) (string, error) {
+	expires := strconv.FormatInt(expiry.Unix(), 10)
+	string_to_sign := fmt.Sprintf("GET\n\n\n%s\n/%s/%s", expires, bucket, escapeObj(obj))
+	hash := sha256.Sum256([]byte(string_to_sign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, g.Key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s?%s", g.URL(bucket, obj), url.Values{
+		"GoogleAccessId": {g.ClientEmail},
+		"Expires":        {expires},
+		"Signature":      {base64.StdEncoding.EncodeToString(signature)},
+	}.Encode()), nil
+}
@@ -0,0 +1,249 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// A stand in for the GCS json api with a single bucket
+type fakeGCS struct {
+	bucket    map[string]interface{} // nil until the bucket is created
+	created   bool
+	patches   []map[string]interface{}
+	uploads   map[string]string // the object names and their contents
+	acls      map[string]string
+	types     map[string]string
+	unhandled []string
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/storage/v1/b/bucket":
+		if f.bucket == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(f.bucket)
+	case r.Method == "POST" && r.URL.Path == "/storage/v1/b":
+		json.Unmarshal(body, &f.bucket)
+		f.created = true
+		json.NewEncoder(w).Encode(f.bucket)
+	case r.Method == "PATCH" && r.URL.Path == "/storage/v1/b/bucket":
+		patch := map[string]interface{}{}
+		json.Unmarshal(body, &patch)
+		f.patches = append(f.patches, patch)
+		f.bucket["lifecycle"] = patch["lifecycle"]
+		json.NewEncoder(w).Encode(f.bucket)
+	case r.Method == "POST" && r.URL.Path == "/upload/storage/v1/b/bucket/o":
+		name := r.URL.Query().Get("name")
+		f.uploads[name] = string(body)
+		f.acls[name] = r.URL.Query().Get("predefinedAcl")
+		f.types[name] = r.Header.Get("Content-Type")
+		w.Write([]byte("{}"))
+	default:
+		f.unhandled = append(f.unhandled, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func newFakeGCS(bucket map[string]interface{}) (*fakeGCS, *httptest.Server, *GCSUploader) {
+	f := &fakeGCS{bucket: bucket, uploads: map[string]string{}, acls: map[string]string{}, types: map[string]string{}}
+	srv := httptest.NewServer(f)
+	conn := &GCSStorage{Endpoint: srv.URL, Project: "project", Client: retryClient()}
+	return f, srv, &GCSUploader{Conn: conn}
+}
+
+func expireIn(t *testing.T, days int) *time.Time {
+	setConfig(t, "uploads_expire", days)
+	expire := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	return &expire
+}
+
+func TestGCSPrepareCreatesBucket(t *testing.T) {
+	setConfig(t, "retries", 0)
+	f, srv, u := newFakeGCS(nil)
+	defer srv.Close()
+	expire := expireIn(t, 30)
+	until, err := u.Prepare("bucket", expire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.created {
+		t.Errorf("expected the bucket to be created")
+	}
+	if until != expire {
+		t.Errorf("expected the public uploads to expire with the files, got %v", until)
+	}
+	if len(f.patches) != 1 {
+		t.Fatalf("expected a lifecycle patch, got %v", f.patches)
+	}
+	rules := f.patches[0]["lifecycle"].(map[string]interface{})["rule"].([]interface{})
+	if len(rules) != 1 {
+		t.Errorf("expected only the expire rule, got %v", rules)
+	}
+	if len(f.unhandled) > 0 {
+		t.Errorf("unexpected requests: %v", f.unhandled)
+	}
+}
+
+func TestGCSPrepareMergesLifecycle(t *testing.T) {
+	setConfig(t, "retries", 0)
+	archive := map[string]interface{}{
+		"action":    map[string]interface{}{"type": "SetStorageClass", "storageClass": "ARCHIVE"},
+		"condition": map[string]interface{}{"age": 365},
+	}
+	f, srv, u := newFakeGCS(map[string]interface{}{
+		"name":      "bucket",
+		"lifecycle": map[string]interface{}{"rule": []interface{}{archive}},
+	})
+	defer srv.Close()
+
+	// the rule is added next to the existing rules
+	if _, err := u.Prepare("bucket", expireIn(t, 30)); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.patches) != 1 {
+		t.Fatalf("expected a lifecycle patch, got %v", f.patches)
+	}
+	rules := f.patches[0]["lifecycle"].(map[string]interface{})["rule"].([]interface{})
+	if len(rules) != 2 || rules[0].(map[string]interface{})["action"].(map[string]interface{})["type"] != "SetStorageClass" {
+		t.Errorf("expected the existing rule to be kept before the expire rule, got %v", rules)
+	}
+
+	// the rule is not patched again once it is there
+	if _, err := u.Prepare("bucket", expireIn(t, 30)); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.patches) != 1 {
+		t.Errorf("expected the lifecycle to be left alone, got %d patches", len(f.patches))
+	}
+
+	// a different age updates the rule in place
+	if _, err := u.Prepare("bucket", expireIn(t, 7)); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.patches) != 2 {
+		t.Fatalf("expected a second lifecycle patch, got %d patches", len(f.patches))
+	}
+	rules = f.patches[1]["lifecycle"].(map[string]interface{})["rule"].([]interface{})
+	age := rules[1].(map[string]interface{})["condition"].(map[string]interface{})["age"]
+	if len(rules) != 2 || age != float64(7) {
+		t.Errorf("expected the expire rule to be updated to 7 days, got %v", rules)
+	}
+}
+
+func TestGCSPutAndURL(t *testing.T) {
+	setConfig(t, "retries", 0)
+	setConfig(t, "uploads_segment_threshold", 1024)
+	f, srv, u := newFakeGCS(map[string]interface{}{"name": "bucket"})
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "run log.txt")
+	ioutil.WriteFile(path, []byte("ok"), 0644)
+	if _, err := u.Prepare("bucket", expireIn(t, 30)); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Put("bucket", "logs/run log.txt", path, "text/plain; charset=utf-8"); err != nil {
+		t.Fatal(err)
+	}
+	name := "upload-expires/logs/run log.txt"
+	if f.uploads[name] != "ok" {
+		t.Fatalf("expected the object '%s' to be uploaded, got %v", name, f.uploads)
+	}
+	if f.acls[name] != "publicRead" || f.types[name] != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected acl '%s' or content type '%s'", f.acls[name], f.types[name])
+	}
+	link, err := u.URL("bucket", "logs/run log.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != srv.URL+"/bucket/upload-expires/logs/run%20log.txt" {
+		t.Errorf("unexpected url: %s", link)
+	}
+
+	// a missing file is reported without a request
+	if err := u.Put("bucket", "missing.log", filepath.Join(t.TempDir(), "missing.log"), "text/plain"); !os.IsNotExist(err) {
+		t.Errorf("expected a missing file error, got %v", err)
+	}
+}
+
+func TestGCSSignedURL(t *testing.T) {
+	setConfig(t, "retries", 0)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, srv, u := newFakeGCS(map[string]interface{}{
+		"name":             "bucket",
+		"iamConfiguration": map[string]interface{}{"uniformBucketLevelAccess": map[string]interface{}{"enabled": true}},
+	})
+	defer srv.Close()
+	u.Conn.ClientEmail = "uploader@project.iam.gserviceaccount.com"
+	u.Conn.Key = key
+
+	path := filepath.Join(t.TempDir(), "run.log")
+	ioutil.WriteFile(path, []byte("ok"), 0644)
+	expire := expireIn(t, 30)
+	until, err := u.Prepare("bucket", expire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Signed || until == nil || !until.Equal(*expire) {
+		t.Fatalf("expected signed urls valid until %s, got %v", expire, until)
+	}
+	if err := u.Put("bucket", "run.log", path, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if acl := f.acls["upload-expires/run.log"]; acl != "" {
+		t.Errorf("expected no object acl with uniform bucket-level access, got '%s'", acl)
+	}
+
+	link, err := u.URL("bucket", "run.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if parsed.Path != "/bucket/upload-expires/run.log" || query.Get("GoogleAccessId") != u.Conn.ClientEmail {
+		t.Errorf("unexpected signed url: %s", link)
+	}
+	if query.Get("Expires") != strconv.FormatInt(expire.Unix(), 10) {
+		t.Errorf("expected the url to expire at %d, got %s", expire.Unix(), query.Get("Expires"))
+	}
+	signature, _ := base64.StdEncoding.DecodeString(query.Get("Signature"))
+	hash := sha256.Sum256([]byte("GET\n\n\n" + query.Get("Expires") + "\n/bucket/upload-expires/run.log"))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+		t.Errorf("the signature does not match: %s", err)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	registerUploader(S3, newS3Uploader)
+}
+
+// The longest validity of a pre-signed url with the V4 signatures
+const S3_PRESIGN_MAX time.Duration = 7 * 24 * time.Hour
+
+// Uploads the files via the S3 API
+type S3Uploader struct {
+	Endpoint   string
+	Conn       *s3.S3
+	Private    bool // the objects are not made public, the urls are pre-signed until the LinkExpiry
+	Expire     *time.Time
+	LinkExpiry time.Time
+}
+
+func newS3Uploader() (Uploader, error) {
+	endpoint := viper.GetString("uploads_endpoint")
+	return &S3Uploader{
+		Endpoint: endpoint,
+		Private:  viper.GetBool("uploads_private"),
+		Conn: s3.New(session.New(), &aws.Config{
+			Endpoint:   aws.String(endpoint),
+			Region:     aws.String(viper.GetString("uploads_region")),
+			MaxRetries: aws.Int(viper.GetInt("retries")), // the errors of the sdk are not retried again by the upload
+		}),
+	}, nil
+}
+
+// Create the bucket if it does not exist, make it public unless private and expire the objects with a lifecycle rule
+func (u *S3Uploader) Prepare(bucket string, expire *time.Time) (*time.Time, error) {
+	u.Expire = expire
+
+	// does bucket exist?
+	head_bucket_params := &s3.HeadBucketInput{
+		Bucket: aws.String(bucket), // Required
+	}
+	_, err := u.Conn.HeadBucket(head_bucket_params)
+	if err != nil { // bucket did not exist, create it...
+		// create a bucket
+		create_bucket_params := &s3.CreateBucketInput{
+			Bucket: aws.String(bucket), // Required
+		}
+		_, err = u.Conn.CreateBucket(create_bucket_params)
+		if err != nil {
+			return nil, fmt.Errorf("Problem creating bucket '%s'\n%s", bucket, err.Error())
+		}
+	}
+
+	// update the acls for the bucket
+	if !u.Private {
+		acl_bucket_params := &s3.PutBucketAclInput{
+			Bucket: aws.String(bucket),
+			ACL:    aws.String(s3.BucketCannedACLPublicRead),
+		}
+		_, err = u.Conn.PutBucketAcl(acl_bucket_params)
+		if err != nil {
+			return nil, fmt.Errorf("Problem updating ACLs to make bucket '%s' public\n%s", bucket, err.Error())
+		}
+	}
+
+	// set the expire time for the bucket
+	if expire != nil {
+		bucket_lifecycle_params := &s3.PutBucketLifecycleConfigurationInput{
+			Bucket: aws.String(bucket), // Required
+			LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+				Rules: []*s3.LifecycleRule{ // Required
+					{ // Required
+						Prefix: aws.String("upload-expires"),           // Required
+						Status: aws.String(s3.ExpirationStatusEnabled), // Required
+						Expiration: &s3.LifecycleExpiration{
+							Date: aws.Time(*expire),
+						},
+					},
+				},
+			},
+		}
+		_, err = u.Conn.PutBucketLifecycleConfiguration(bucket_lifecycle_params)
+		if err != nil {
+			return nil, fmt.Errorf("Problem updating lifecycle to automatically expire objects in bucket '%s'\n%s", bucket, err.Error())
+		}
+	}
+
+	// the pre-signed urls can not outlive the limit of the V4 signatures
+	if u.Private {
+		u.LinkExpiry = *expire
+		if u.LinkExpiry.Sub(time.Now()) > S3_PRESIGN_MAX {
+			u.LinkExpiry = time.Now().Add(S3_PRESIGN_MAX).Round(0) // strip the monotonic clock reading
+			log.Printf("WARNING: The '%s' api pre-signs urls for at most 7 days, the links will expire before the files.\n", S3)
+		}
+		return &u.LinkExpiry, nil
+	}
+	return expire, nil
+}
+
+// Upload the object and make it public, large files are sent as a multipart upload with each part retried by the sdk
+func (u *S3Uploader) Put(bucket, obj, path, content_type string) error {
+	obj = expiringKey(obj, u.Expire)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > uploadsSegmentThreshold() {
+		upload_params := &s3manager.UploadInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(obj),
+			Body:        f,
+			ContentType: aws.String(content_type),
+		}
+		if u.Expire != nil {
+			upload_params.Expires = aws.Time(*u.Expire)
+		}
+		uploader := s3manager.NewUploaderWithClient(u.Conn, func(up *s3manager.Uploader) {
+			up.PartSize = uploadsSegmentSize()
+		})
+		_, err = uploader.Upload(upload_params)
+	} else {
+		put_obj_params := &s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(obj),
+			Body:        f,
+			ContentType: aws.String(content_type),
+		}
+		if u.Expire != nil {
+			put_obj_params.Expires = aws.Time(*u.Expire)
+		}
+		_, err = u.Conn.PutObject(put_obj_params)
+	}
+	if err != nil {
+		return &retriedError{err: err}
+	}
+	if u.Private {
+		return nil
+	}
+	// update the acls for the object
+	acl_object_params := &s3.PutObjectAclInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(obj),
+		ACL:    aws.String(s3.ObjectCannedACLPublicRead),
+	}
+	_, err = u.Conn.PutObjectAcl(acl_object_params)
+	if err != nil {
+		return &retriedError{err: fmt.Errorf("updating ACLs to make object '%s' public: %s", obj, err.Error())}
+	}
+	return nil
+}
+
+func (u *S3Uploader) URL(bucket, obj string) (string, error) {
+	obj = expiringKey(obj, u.Expire)
+	if u.Private {
+		req, _ := u.Conn.GetObjectRequest(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(obj),
+		})
+		return req.Presign(u.LinkExpiry.Sub(time.Now()))
+	}
+	return fmt.Sprintf("%s/%s/%s", strings.TrimRight(u.Endpoint, "/"), bucket, obj), nil
+}
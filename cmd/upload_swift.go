@@ -0,0 +1,247 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ncw/swift"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	registerUploader(SWIFT, newSwiftUploader)
+}
+
+// Uploads the files via the Swift API
+type SwiftUploader struct {
+	Conn       *swift.Connection
+	Private    bool // the container is not made public, the urls are TempURLs signed with the TempURLKey
+	TempURLKey string
+	Expire     *time.Time
+
+	container_key bool // the TempURLKey is a container key, so it is also set on the segments container
+
+	segments     sync.Once // the segments container is only prepared for the first large file
+	segments_err error
+	slo          bool // the cluster supports Static Large Objects, otherwise Dynamic Large Objects are used
+}
+
+func newSwiftUploader() (Uploader, error) {
+	// get the details about the identity (tenant and user)
+	parts := strings.Split(viper.GetString("uploads_identity"), ":")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("The 'uploads_identity' flag for '%s' is formatted as 'tenant:username'", SWIFT)
+	}
+
+	// make a swift connection
+	conn := &swift.Connection{
+		Tenant:   parts[0],
+		UserName: parts[1],
+		ApiKey:   viper.GetString("uploads_secret"),
+		AuthUrl:  viper.GetString("uploads_endpoint"),
+		// the uploads are retried as a whole, since the file bodies can not be read again
+		Transport: &retryTransport{Base: http.DefaultTransport},
+	}
+
+	// authenticate swift user
+	err := conn.Authenticate()
+	if err != nil {
+		return nil, fmt.Errorf("Swift authentication failed.  Validate your credentials are correct.")
+	}
+	return &SwiftUploader{Conn: conn, Private: viper.GetBool("uploads_private")}, nil
+}
+
+// Create the container if it does not exist and make it public unless private, the objects expire individually
+func (u *SwiftUploader) Prepare(bucket string, expire *time.Time) (*time.Time, error) {
+	u.Expire = expire
+
+	// create the container if it does not already exist
+	err := u.Conn.ContainerCreate(bucket, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Problem creating bucket '%s'\n%s", bucket, err.Error())
+	}
+
+	if u.Private {
+		err = u.tempURLKey(bucket)
+		if err != nil {
+			return nil, fmt.Errorf("Problem getting the TempURL key of bucket '%s'\n%s", bucket, err.Error())
+		}
+		return expire, nil
+	}
+
+	err = u.makePublic(bucket)
+	if err != nil {
+		return nil, fmt.Errorf("Problem updating headers to make bucket '%s' public\n%s", bucket, err.Error())
+	}
+	return expire, nil
+}
+
+func (u *SwiftUploader) makePublic(bucket string) error {
+	// update container headers
+	metadata := make(swift.Metadata, 0)
+	headers := metadata.ContainerHeaders()
+	headers["X-Container-Read"] = ".r:*,.rlistings" // make the container public
+	return u.Conn.ContainerUpdate(bucket, headers)
+}
+
+// Create the '<bucket>_segments' container holding the segments of the large objects, readable like the bucket
+func (u *SwiftUploader) prepareSegments(bucket string) error {
+	u.segments.Do(func() {
+		info, err := u.Conn.QueryInfo()
+		u.slo = err == nil && info.SupportsSLO()
+		segments_bucket := bucket + "_segments"
+		u.segments_err = u.Conn.ContainerCreate(segments_bucket, nil)
+		if u.segments_err == nil && !u.Private {
+			u.segments_err = u.makePublic(segments_bucket)
+		}
+		if u.segments_err == nil && u.Private && u.container_key {
+			u.segments_err = u.Conn.ContainerUpdate(segments_bucket, swift.Headers{"X-Container-Meta-Temp-Url-Key": u.TempURLKey})
+		}
+		if u.segments_err != nil {
+			u.segments_err = fmt.Errorf("creating the segments bucket '%s': %s", segments_bucket, u.segments_err.Error())
+		}
+	})
+	return u.segments_err
+}
+
+// Use the TempURL key of the account, or of the container which is set to a random key if it has none
+func (u *SwiftUploader) tempURLKey(bucket string) error {
+	_, headers, err := u.Conn.Account()
+	if err != nil {
+		return err
+	}
+	u.TempURLKey = headers["X-Account-Meta-Temp-Url-Key"]
+	if u.TempURLKey != "" {
+		return nil
+	}
+	_, headers, err = u.Conn.Container(bucket)
+	if err != nil {
+		return err
+	}
+	u.TempURLKey = headers["X-Container-Meta-Temp-Url-Key"]
+	u.container_key = true
+	if u.TempURLKey != "" {
+		return nil
+	}
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	if err != nil {
+		return err
+	}
+	u.TempURLKey = hex.EncodeToString(key)
+	return u.Conn.ContainerUpdate(bucket, swift.Headers{"X-Container-Meta-Temp-Url-Key": u.TempURLKey})
+}
+
+func (u *SwiftUploader) Put(bucket, obj, path, content_type string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	obj_metadata := make(swift.Metadata, 0)
+	obj_headers := obj_metadata.ObjectHeaders()
+	if u.Expire != nil {
+		obj_headers["X-Delete-At"] = fmt.Sprintf("%d", u.Expire.Unix())
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > uploadsSegmentThreshold() {
+		return u.putLarge(bucket, obj, f, info.Size(), content_type, obj_headers)
+	}
+	// currently NOT validating the hash of the upload since I expect large files
+	_, err = u.Conn.ObjectPut(bucket, obj, f, false, "", content_type, obj_headers)
+	return err
+}
+
+// Upload a large file as segments in the '<bucket>_segments' container, each retried on its own,
+// joined by a Static Large Object manifest, or a Dynamic Large Object manifest if the cluster does not support them.
+func (u *SwiftUploader) putLarge(bucket, obj string, f *os.File, size int64, content_type string, headers swift.Headers) error {
+	err := u.prepareSegments(bucket)
+	if err != nil {
+		return err
+	}
+	segment_size := uploadsSegmentSize()
+	segments_bucket := bucket + "_segments"
+	prefix := fmt.Sprintf("%s/%d/%d/%d/", obj, time.Now().Unix(), size, segment_size)
+
+	manifest := []map[string]interface{}{}
+	for i, offset := 0, int64(0); offset < size; i, offset = i+1, offset+segment_size {
+		length := size - offset
+		if length > segment_size {
+			length = segment_size
+		}
+		segment := fmt.Sprintf("%s%08d", prefix, i)
+		var segment_headers swift.Headers
+		err = retry(fmt.Sprintf("Uploading segment '%s'", segment), func() error {
+			var err error
+			segment_headers, err = u.Conn.ObjectPut(segments_bucket, segment, io.NewSectionReader(f, offset, length), false, "", "", headers)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		manifest = append(manifest, map[string]interface{}{
+			"path":       fmt.Sprintf("%s/%s", segments_bucket, segment),
+			"etag":       segment_headers["Etag"],
+			"size_bytes": length,
+		})
+	}
+
+	manifest_headers := swift.Headers{}
+	for k, v := range headers {
+		manifest_headers[k] = v
+	}
+	if u.slo {
+		body, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		manifest_headers["Content-Type"] = content_type
+		_, _, err = u.Conn.Call(u.Conn.StorageUrl, swift.RequestOpts{
+			Container:  bucket,
+			ObjectName: obj,
+			Operation:  "PUT",
+			Parameters: url.Values{"multipart-manifest": {"put"}},
+			Headers:    manifest_headers,
+			Body:       bytes.NewReader(body),
+			NoResponse: true,
+		})
+		return err
+	}
+	manifest_headers["X-Object-Manifest"] = segments_bucket + "/" + prefix
+	_, err = u.Conn.ObjectPut(bucket, obj, bytes.NewReader(nil), false, "", content_type, manifest_headers)
+	return err
+}
+
+func (u *SwiftUploader) URL(bucket, obj string) (string, error) {
+	if u.Private {
+		return u.Conn.ObjectTempUrl(bucket, obj, u.TempURLKey, "GET", *u.Expire), nil
+	}
+	return fmt.Sprintf("%s/%s/%s", strings.TrimRight(u.Conn.StorageUrl, "/"), bucket, obj), nil
+}
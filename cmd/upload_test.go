@@ -0,0 +1,190 @@
+// Copyright © 2016 Will Stevens <wstevens@cloudops.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An in memory uploader which fails the objects containing 'bad'
+type fakeUploader struct {
+	until   *time.Time
+	expire  *time.Time
+	mu      sync.Mutex
+	active  int
+	max     int
+	objects map[string]string
+}
+
+func (u *fakeUploader) Prepare(bucket string, expire *time.Time) (*time.Time, error) {
+	u.expire = expire
+	u.objects = map[string]string{}
+	return u.until, nil
+}
+
+func (u *fakeUploader) Put(bucket, obj, path, content_type string) error {
+	u.mu.Lock()
+	u.active++
+	if u.active > u.max {
+		u.max = u.active
+	}
+	u.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.active--
+	if strings.Contains(obj, "bad") {
+		return errors.New("upload refused")
+	}
+	u.objects[obj] = path
+	return nil
+}
+
+func (u *fakeUploader) URL(bucket, obj string) (string, error) {
+	return "https://uploads.example.com/" + bucket + "/" + obj, nil
+}
+
+func fakeComment(names ...string) *CommentBody {
+	c := &CommentBody{Uploads: map[string][]Upload{}}
+	for _, name := range names {
+		dir := name[:strings.Index(name, "/")]
+		c.Uploads[dir] = append(c.Uploads[dir], Upload{Name: name, Path: "/tmp/" + name, Obj: name})
+	}
+	return c
+}
+
+func setUploadsConfig(t *testing.T, on_error string, expire int) {
+	setConfig(t, "uploads_bucket", "bucket")
+	setConfig(t, "uploads_concurrency", 3)
+	setConfig(t, "uploads_expire", expire)
+	setConfig(t, "uploads_on_error", on_error)
+	setConfig(t, "retries", 0)
+}
+
+func TestUploadConcurrency(t *testing.T) {
+	setUploadsConfig(t, FAIL, 0)
+	c := fakeComment("a/1.log", "a/2.log", "a/3.log", "b/4.log", "b/5.log", "b/6.log", "c/7.log")
+	uploader := &fakeUploader{}
+	if err := c.UploadWith(uploader); err != nil {
+		t.Fatalf("upload failed: %s", err)
+	}
+	if len(uploader.objects) != 7 {
+		t.Errorf("expected 7 objects, got %d", len(uploader.objects))
+	}
+	if uploader.max < 2 || uploader.max > 3 {
+		t.Errorf("expected 2 to 3 concurrent uploads, got %d", uploader.max)
+	}
+}
+
+func TestUploadURLAndExpiry(t *testing.T) {
+	setUploadsConfig(t, FAIL, 2)
+	until := time.Now().Add(48 * time.Hour).Round(0)
+	c := fakeComment("a/1.log", "b/2.log")
+	uploader := &fakeUploader{until: &until}
+	if err := c.UploadWith(uploader); err != nil {
+		t.Fatalf("upload failed: %s", err)
+	}
+	if uploader.expire == nil || uploader.expire.Before(until) {
+		t.Errorf("expected the uploader to be prepared with an expiry after %s, got %v", until, uploader.expire)
+	}
+	if c.UploadsExpire == nil || !c.UploadsExpire.Equal(until) {
+		t.Errorf("expected the comment to expire at %s, got %v", until, c.UploadsExpire)
+	}
+	for _, uploads := range c.Uploads {
+		for _, u := range uploads {
+			if _, ok := uploader.objects[u.Obj]; !ok {
+				t.Errorf("object '%s' was not uploaded under its own name", u.Obj)
+			}
+			if u.URL != "https://uploads.example.com/bucket/"+u.Obj {
+				t.Errorf("unexpected url for '%s': %s", u.Obj, u.URL)
+			}
+		}
+	}
+}
+
+func TestUploadFailures(t *testing.T) {
+	names := []string{"a/1.log", "a/bad.log", "b/bad.log", "c/2.log"}
+
+	setUploadsConfig(t, FAIL, 0)
+	c := fakeComment(names...)
+	if err := c.UploadWith(&fakeUploader{}); err == nil || !strings.Contains(err.Error(), "2 file(s)") {
+		t.Errorf("expected 2 failed files with the fail policy, got %v", err)
+	}
+
+	setUploadsConfig(t, WARN, 0)
+	c = fakeComment(names...)
+	if err := c.UploadWith(&fakeUploader{}); err != nil {
+		t.Fatalf("unexpected error with the warn policy: %s", err)
+	}
+	for _, uploads := range c.Uploads {
+		for _, u := range uploads {
+			bad := strings.Contains(u.Obj, "bad")
+			if u.Failed != bad || (u.URL == "") != bad {
+				t.Errorf("unexpected state for '%s': failed %v, url '%s'", u.Obj, u.Failed, u.URL)
+			}
+		}
+	}
+
+	setUploadsConfig(t, SKIP, 0)
+	c = fakeComment(names...)
+	if err := c.UploadWith(&fakeUploader{}); err != nil {
+		t.Fatalf("unexpected error with the skip policy: %s", err)
+	}
+	if len(c.Uploads["a"]) != 1 || c.Uploads["a"][0].Obj != "a/1.log" {
+		t.Errorf("expected only 'a/1.log' to be kept in 'a', got %v", c.Uploads["a"])
+	}
+	if _, ok := c.Uploads["b"]; ok {
+		t.Errorf("expected the emptied dir 'b' to be removed")
+	}
+	if len(c.Uploads["c"]) != 1 {
+		t.Errorf("expected 'c/2.log' to be kept, got %v", c.Uploads["c"])
+	}
+}
+
+func TestUploadUnreadableFiles(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.log")
+	missing := filepath.Join(dir, "missing.log")
+	ioutil.WriteFile(good, []byte("ok"), 0644)
+
+	setUploadsConfig(t, FAIL, 0)
+	c := &CommentBody{}
+	c.PopulateUploads(good + "," + missing)
+	uploader := &fakeUploader{}
+	if err := c.UploadWith(uploader); err == nil || !strings.Contains(err.Error(), "1 file(s)") {
+		t.Errorf("expected the missing file to fail the uploads, got %v", err)
+	}
+	if len(uploader.objects) != 1 {
+		t.Errorf("expected only the readable file to be uploaded, got %v", uploader.objects)
+	}
+
+	setUploadsConfig(t, WARN, 0)
+	c = &CommentBody{}
+	c.PopulateUploads(good + "," + missing)
+	if err := c.UploadWith(&fakeUploader{}); err != nil {
+		t.Fatalf("unexpected error with the warn policy: %s", err)
+	}
+	for _, u := range c.Uploads[dir] {
+		if (u.Path == missing) != u.Failed {
+			t.Errorf("unexpected state for '%s': failed %v", u.Path, u.Failed)
+		}
+	}
+}
@@ -1,4 +1,6 @@
 {{define "pr_comment" -}}
+{{with .Marker}}{{.}}{{if $.CommitID}}<!-- upr-commit:{{$.CommitID}} -->{{end}}
+{{end -}}
 {{if .Title -}}
 ### {{.Title}}
 
@@ -9,6 +11,25 @@
 {{- end}}
 {{.Summary}}
 
+{{with .TestResults -}}
+**Test Results**
+
+| Total | Passed | Failed | Skipped | Duration |
+|------:|-------:|-------:|--------:|---------:|
+| {{.Total}} | {{.Passed}} | {{.Failed}} | {{.Skipped}} | {{.Duration}} |
+
+{{range $failure := .Failures -}}
+<details>
+<summary>:x: {{$failure.Name}}{{if $failure.Message}}: {{$failure.Message}}{{end}}</summary>
+{{if $failure.Details}}
+```
+{{$failure.Details}}
+```
+{{end -}}
+</details>
+
+{{end}}
+{{end -}}
 {{if .Uploads -}}
 **Associated Uploads**
 
@@ -17,12 +38,29 @@
 **`{{$dir}}:`**
 {{- end}}
 {{range $upload := $uploads -}}
+{{if $upload.Failed -}}
+* {{$upload.Name}} :warning: *upload failed*
+{{- else -}}
 * [{{$upload.Name}}]({{$upload.URL}})
+{{- end}}
 {{end}}
 {{end}}
 {{if .UploadsExpire -}}
-Uploads will be available until `{{.UploadsExpire}}`
+{{if .UploadsPrivate}}Upload links are private and will expire at{{else}}Uploads will be available until{{end}} `{{.UploadsExpire}}`
 {{end}}
 *Comment created by [`upr comment`](https://github.com/cloudops/upr).*
 {{- end}}
-{{end}}
\ No newline at end of file
+{{end}}
+{{define "pr_comment_outdated" -}}
+<details>
+<summary>Outdated{{if .CommitID}} (commit {{printf "%.7s" .CommitID}}){{end}}</summary>
+
+{{.Body}}
+</details>
+{{- end}}
+
+{{define "check_summary" -}}
+{{.Summary}}
+
+*Check run created by [`upr check`](https://github.com/cloudops/upr).*
+{{- end}}